@@ -0,0 +1,105 @@
+package webbrick
+
+import (
+	"errors" // For crafting our own errors
+	"fmt"    // For wrapping errors
+	"sort"   // For stable listings
+)
+
+// ErrUnknownBrick is returned when asked about a brick we haven't fetched the config for
+var ErrUnknownBrick = errors.New("unknown brick")
+
+// storeBrick keeps the last config and status we fetched from a brick
+func (c *Client) storeBrick(_wbc WebbrickConfig, _wbs WebbrickStatus) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.configs[_wbs.BrickNo] = _wbc
+	c.statuses[_wbs.BrickNo] = _wbs
+}
+
+// BrickConfig is the last WbCfg.xml we fetched from a brick
+func (c *Client) BrickConfig(brickID int) (WebbrickConfig, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_wbc, ok := c.configs[brickID]
+	if !ok {
+		return WebbrickConfig{}, fmt.Errorf("%w: %d", ErrUnknownBrick, brickID)
+	}
+	return _wbc, nil
+}
+
+// BrickStatus is the last WbStatus.xml we fetched from a brick
+func (c *Client) BrickStatus(brickID int) (WebbrickStatus, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_wbs, ok := c.statuses[brickID]
+	if !ok {
+		return WebbrickStatus{}, fmt.Errorf("%w: %d", ErrUnknownBrick, brickID)
+	}
+	return _wbs, nil
+}
+
+// Bricks are the numbers of the bricks we've fetched the config for, in order
+func (c *Client) Bricks() []int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	bricks := make([]int, 0, len(c.configs))
+	for brickID := range c.configs {
+		bricks = append(bricks, brickID)
+	}
+	sort.Ints(bricks)
+	return bricks
+}
+
+// knownBricks is every brick we've heard from, sorted, including ones we've only had a
+// heartbeat from and haven't fetched a config for
+func (c *Client) knownBricks() []int {
+
+	known := make(map[int]bool)
+	for _, brickID := range c.Bricks() {
+		known[brickID] = true
+	}
+	for _, dev := range c.Devices.All() {
+		known[dev.BrickID] = true
+	}
+
+	bricks := make([]int, 0, len(known))
+	for brickID := range known {
+		bricks = append(bricks, brickID)
+	}
+	sort.Ints(bricks)
+	return bricks
+}
+
+// DecommissionBrick forgets a brick that has been taken out: it stops polling it, drops
+// its devices, config, status and health, and raises a "deviceremoved" event for each
+// device so bridges can tidy up after it. It hands back the devices it removed
+func (c *Client) DecommissionBrick(brickID int) ([]Device, error) {
+
+	c.StopPolling(brickID)
+
+	removed := c.Devices.removeBrick(brickID)
+
+	c.mu.Lock()
+	_, known := c.configs[brickID]
+	delete(c.configs, brickID)
+	delete(c.statuses, brickID)
+	delete(c.health, brickID)
+	delete(c.transports, brickID)
+	c.mu.Unlock()
+
+	if len(removed) == 0 && !known {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownBrick, brickID)
+	}
+
+	myLog.Infof("   **** Decommissioned brick %d, %d devices removed", brickID, len(removed))
+	for _, dev := range removed {
+		c.passMessage("deviceremoved", EventRemoved, SourceCommand, dev, dev, nil)
+	}
+
+	return removed, nil
+}
@@ -0,0 +1,115 @@
+package webbrick
+
+import (
+	"net"      // For networking stuff - for UDP
+	"net/http" // For web http calls
+	"sync"     // For guarding the transports
+	"time"     // For the http timeout
+)
+
+// httpTimeout stops a brick that has gone away from hanging a status fetch or command forever
+const httpTimeout = 30 * time.Second
+
+// Client is a single webbrick bridge. It owns its UDP connection, the devices it has
+// discovered and the channel it raises events on, so more than one can run in a process
+type Client struct {
+	Config  *WebbrickDriverConfig
+	Events  chan EventStruct // Events is our events channel which will notify calling code that we have an event happening. It drops events nobody reads, and is only kept for older code, use Subscribe to lose nothing
+	Devices *DeviceRegistry  // All the Devices we've discovered
+	UDPPort string           // UDP Port
+	Debug   bool             // Dump out packets and devices as we go
+	Poll    bool             // Keep polling the bricks for status after the first fetch, set by POLL or PollingActive
+	Pirs    map[string]bool  // Trigger inputs that are PIRs rather than buttons
+	Exclude map[string]bool  // Devices on the webbricks that aren't in use
+
+	ReadTimeout  time.Duration // How long Run waits on a read before checking for cancellation
+	Transport    Transport     // How commands get to the bricks, unless SetTransport says otherwise. nil is HTTP through the Endpoint
+	Endpoint     Endpoint      // How to reach the bricks' web servers, nil for Direct
+	PollJitter   float64       // How far polls drift either side of the interval, as a fraction of it
+	OfflineAfter time.Duration // How long a brick can be silent before it is offline, 0 never
+
+	localIP    string       // Our own IP, so we can ignore our own messages
+	httpClient *http.Client // For the status, config and command calls
+
+	mu         sync.Mutex             // Guards the below
+	conn       *net.UDPConn           // UDP Connection, see udpConn
+	transports map[int]Transport      // Per brick transports, keyed on brick number
+	configs    map[int]WebbrickConfig // Last config fetched, keyed on brick number
+	statuses   map[int]WebbrickStatus // Last status fetched, keyed on brick number
+	pollers    map[int]func()         // Cancels each brick's poller, keyed on brick number
+	health     map[int]*BrickHealth   // Whether each brick is up, keyed on brick number
+	stopHealth func()                 // Stops the health watchdog, nil when it isn't running
+	heard      map[string]time.Time   // When we last had a packet from each address, for discovery
+
+	subsMu sync.RWMutex  // Guards subs
+	subs   []*subscriber // Everyone getting events, the Events channel included
+
+	stats   stats  // Running counts, see Stats
+	dropped uint64 // Atomic, every event any subscriber has ever lost, for Stats
+}
+
+// NewClient builds a Client from the config. A nil config gets the defaults
+func NewClient(wbdc *WebbrickDriverConfig) *Client {
+
+	if wbdc == nil {
+		wbdc = &WebbrickDriverConfig{
+			Name:            "PKHome",
+			Initialised:     false,
+			NumberOfDevices: 0,
+			PollingMinutes:  5,
+			PollingActive:   false,
+		}
+	}
+
+	c := &Client{
+		Events:  make(chan EventStruct, 50),
+		Devices: NewDeviceRegistry(),
+
+		ReadTimeout:  readTimeout,
+		PollJitter:   defaultPollJitter,
+		OfflineAfter: defaultOfflineAfter,
+		httpClient:   &http.Client{Timeout: httpTimeout},
+		transports:   make(map[int]Transport),
+		configs:      make(map[int]WebbrickConfig),
+		statuses:     make(map[int]WebbrickStatus),
+		pollers:      make(map[int]func()),
+		health:       make(map[int]*BrickHealth),
+		heard:        make(map[string]time.Time),
+	}
+
+	c.configure(wbdc)
+
+	// The Events channel is just another subscriber, one that drops what it can't buffer
+	c.addSubscriber(&subscriber{ch: c.Events, filter: EventFilter{Policy: DropNewest}, done: make(chan struct{}), legacy: true})
+
+	return c
+}
+
+// configure takes on everything in the config. The default client is built before anyone
+// has a config for it, so Prepare does this again once they have
+func (c *Client) configure(wbdc *WebbrickDriverConfig) {
+
+	c.Config = wbdc
+	c.UDPPort = wbdc.UDPPort
+	c.Debug = wbdc.Debug
+	c.Poll = POLL || wbdc.PollingActive
+	c.Pirs = wbdc.Pirs
+	c.Exclude = wbdc.Exclude
+
+	if wbdc.Gateway != nil {
+		c.Endpoint = wbdc.Gateway
+	}
+	if c.UDPPort == "" {
+		c.UDPPort = UDPPort
+	}
+	if c.Pirs == nil {
+		c.Pirs = PIRS
+	}
+	if c.Exclude == nil {
+		c.Exclude = EXCLUDE
+	}
+
+	for _, brickID := range wbdc.UDPCommandBricks {
+		c.UseUDP(brickID)
+	}
+}
@@ -0,0 +1,42 @@
+package webbrick
+
+import "testing"
+
+func TestConfigure(t *testing.T) {
+
+	pirs := map[string]bool{"2::TD::6": true}
+	gateway := &Gateway{Host: "home.example.com"}
+
+	// the default client is built with no config, and picks it all up later
+	c := NewClient(nil)
+	c.configure(&WebbrickDriverConfig{
+		UDPPort:          "2553",
+		Pirs:             pirs,
+		UDPCommandBricks: []int{3},
+		Gateway:          gateway,
+	})
+
+	if c.UDPPort != "2553" {
+		t.Errorf("UDPPort %q, want 2553", c.UDPPort)
+	}
+	if !c.Pirs["2::TD::6"] || len(c.Pirs) != 1 {
+		t.Errorf("Pirs %v, want %v", c.Pirs, pirs)
+	}
+	if len(c.Exclude) != len(EXCLUDE) {
+		t.Errorf("Exclude has %d devices, want the %d in EXCLUDE", len(c.Exclude), len(EXCLUDE))
+	}
+	if c.Endpoint != Endpoint(gateway) {
+		t.Errorf("Endpoint %v, want the gateway", c.Endpoint)
+	}
+	if _, ok := c.transportFor(3).(*UDPTransport); !ok {
+		t.Errorf("brick 3 commands go over %T, want UDP", c.transportFor(3))
+	}
+	if _, ok := c.transportFor(2).(*UDPTransport); ok {
+		t.Error("brick 2 commands go over UDP, want HTTP")
+	}
+
+	c.configure(&WebbrickDriverConfig{})
+	if c.UDPPort != UDPPort {
+		t.Errorf("UDPPort %q, want the default %q", c.UDPPort, UDPPort)
+	}
+}
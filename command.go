@@ -0,0 +1,214 @@
+package webbrick
+
+import (
+	"fmt"     // For building the commands
+	"net"     // For the brick's address
+	"net/url" // For encoding the commands
+	"strconv" // For String construction
+	"strings" // For joining commands up
+	"time"    // For setting the clock
+)
+
+// Command builds a request for hid.spi, the brick's command page. Each method adds one
+// com= command and they all go to the brick in one request, in the order they were added:
+//
+//	cmd := NewCommand().DigitalOn(2).AnalogueFade(0, 85, 4)
+//	http.Get(cmd.URL("10.100.100.101"))
+type Command struct {
+	coms []string
+}
+
+// NewCommand starts an empty command
+func NewCommand() *Command {
+	return &Command{}
+}
+
+// Raw adds a command as is, for anything the builder doesn't cover
+func (c *Command) Raw(com string) *Command {
+	c.coms = append(c.coms, com)
+	return c
+}
+
+// DigitalOn turns a digital output on
+func (c *Command) DigitalOn(channel int) *Command {
+	return c.Raw("DO" + strconv.Itoa(channel) + ";N")
+}
+
+// DigitalOff turns a digital output off
+func (c *Command) DigitalOff(channel int) *Command {
+	return c.Raw("DO" + strconv.Itoa(channel) + ";F")
+}
+
+// DigitalToggle flips a digital output
+func (c *Command) DigitalToggle(channel int) *Command {
+	return c.Raw("DO" + strconv.Itoa(channel) + ";T")
+}
+
+// DigitalDwell turns a digital output on for one of the brick's dwell times (CW 0-7), then off
+func (c *Command) DigitalDwell(channel int, dwell int) *Command {
+	return c.Raw("DO" + strconv.Itoa(channel) + ";D" + strconv.Itoa(dwell))
+}
+
+// AnalogueSet sets an analogue output, 0-100, straight away
+func (c *Command) AnalogueSet(channel int, level int) *Command {
+	return c.Raw("AA" + strconv.Itoa(channel) + ";" + strconv.Itoa(level))
+}
+
+// AnalogueFade sets an analogue output, 0-100, fading to it at the given rate
+func (c *Command) AnalogueFade(channel int, level int, rate int) *Command {
+	return c.Raw("AA" + strconv.Itoa(channel) + ";" + strconv.Itoa(level) + ";" + strconv.Itoa(rate))
+}
+
+// TriggerInput triggers a digital input, so the brick runs whatever action it is set up with
+func (c *Command) TriggerInput(channel int) *Command {
+	return c.Raw("DI" + strconv.Itoa(channel))
+}
+
+// Scene recalls one of the brick's scenes
+func (c *Command) Scene(scene int) *Command {
+	return c.Raw("SC" + strconv.Itoa(scene))
+}
+
+// SetPoint changes one of the preset levels (CS 0-7), 0-100
+func (c *Command) SetPoint(setPoint int, level int) *Command {
+	return c.Raw("SP" + strconv.Itoa(setPoint) + ";" + strconv.Itoa(level))
+}
+
+// SetClock sets the brick's clock. Bricks count the days of the week from Sunday as 0
+func (c *Command) SetClock(t time.Time) *Command {
+	return c.Raw(fmt.Sprintf("ST%d;%d;%d", t.Hour(), t.Minute(), int(t.Weekday())))
+}
+
+// SetNodeName changes the name the brick goes by
+func (c *Command) SetNodeName(name string) *Command {
+	return c.Raw("NN" + name)
+}
+
+// Reboot restarts the brick. Anything after it in the same request is lost
+func (c *Command) Reboot() *Command {
+	return c.Raw("RB")
+}
+
+//////////////////////////////////
+//
+// Config commands - these change WbCfg.xml
+//
+//////////////////////////////////
+
+// ConfigDigitalInput sets a digital input's name, options and trigger
+func (c *Command) ConfigDigitalInput(cd CD) *Command {
+	return c.Raw(fmt.Sprintf("CD%d;%s;%d;%s", cd.Id, cd.Name, cd.Opt, trgArgs(cd.Trg.B1, cd.Trg.B2, cd.Trg.B3, cd.Trg.B4)))
+}
+
+// ConfigTemperature sets a temperature sensor's name, thresholds and their triggers
+func (c *Command) ConfigTemperature(ct CT) *Command {
+	return c.Raw(fmt.Sprintf("CT%d;%s;%s", ct.Id, ct.Name, thresholdArgs(ct.TrgL, ct.TrgH)))
+}
+
+// ConfigAnalogueInput sets an analogue input's name, thresholds and their triggers
+func (c *Command) ConfigAnalogueInput(ci CI) *Command {
+	return c.Raw(fmt.Sprintf("CI%d;%s;%s", ci.Id, ci.Name, thresholdArgs(ci.TrgL, ci.TrgH)))
+}
+
+// ConfigSchedule sets a scheduled event's days, time and trigger
+func (c *Command) ConfigSchedule(ce CE) *Command {
+	return c.Raw(fmt.Sprintf("CE%d;%d;%d;%d;%s", ce.Id, ce.Days, ce.Hours, ce.Mins, trgArgs(ce.Trg.B1, ce.Trg.B2, ce.Trg.B3, ce.Trg.B4)))
+}
+
+// ConfigScene sets a scene's masks and what it sets the outputs to
+func (c *Command) ConfigScene(cc CC) *Command {
+	return c.Raw(fmt.Sprintf("CC%d;%d;%d;%d;%d", cc.Id, cc.DigitalMask, cc.DigitalState, cc.AnalogueMask, cc.AnalogueSet))
+}
+
+// ConfigDwell sets a dwell time, in seconds
+func (c *Command) ConfigDwell(cw CW) *Command {
+	return c.Raw(fmt.Sprintf("CW%d;%d", cw.Id, cw.Value))
+}
+
+// NameDigitalOutput sets a digital output's name
+func (c *Command) NameDigitalOutput(no NO) *Command {
+	return c.Raw(fmt.Sprintf("NO%d;%s", no.Id, no.Name))
+}
+
+// NameAnalogueOutput sets an analogue output's name
+func (c *Command) NameAnalogueOutput(na NA) *Command {
+	return c.Raw(fmt.Sprintf("NA%d;%s", na.Id, na.Name))
+}
+
+// ConfigMimics sets the mimic LEDs up
+func (c *Command) ConfigMimics(mm MM) *Command {
+	return c.Raw(fmt.Sprintf("MM;%d;%d;%d;%d;%d", mm.Lo, mm.Hi, mm.Digital, mm.Analogue, mm.Fade))
+}
+
+// ConfigSR sets one of the SR settings
+func (c *Command) ConfigSR(sr SR) *Command {
+	return c.Raw(fmt.Sprintf("SR%d;%d", sr.Id, sr.Value))
+}
+
+// ConfigSF sets the SF setting
+func (c *Command) ConfigSF(sf int) *Command {
+	return c.Raw("SF" + strconv.Itoa(sf))
+}
+
+// trgArgs are the trigger bytes as command arguments
+func trgArgs(b1, b2, b3, b4 int) string {
+	return fmt.Sprintf("%d;%d;%d;%d", b1, b2, b3, b4)
+}
+
+// thresholdArgs are a low and high threshold and their triggers as command arguments
+func thresholdArgs(lo TrgL, hi TrgH) string {
+	return fmt.Sprintf("%d;%s;%d;%s", lo.Lo, trgArgs(lo.B1, lo.B2, lo.B3, lo.B4), hi.Hi, trgArgs(hi.B1, hi.B2, hi.B3, hi.B4))
+}
+
+// Len is how many commands have been added
+func (c *Command) Len() int {
+	return len(c.coms)
+}
+
+// Coms are the commands in the order they will be sent
+func (c *Command) Coms() []string {
+	return append([]string(nil), c.coms...)
+}
+
+// Query is the encoded query string. The brick wants each batch wrapped in ":" commands
+func (c *Command) Query() string {
+	coms := append([]string{":"}, c.coms...)
+	coms = append(coms, ":")
+	return url.Values{"com": coms}.Encode()
+}
+
+// URL is the full hid.spi URL on the brick at host
+func (c *Command) URL(host string) string {
+	return "http://" + host + "/hid.spi?" + c.Query()
+}
+
+// String lists the commands, for logs and dry runs
+func (c *Command) String() string {
+	return strings.Join(c.coms, " ")
+}
+
+// SendCommand sends a batch of commands to a brick over hid.spi in one request
+func (c *Client) SendCommand(brickID int, cmd *Command) error {
+
+	ip, err := c.brickIP(brickID)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	err = c.httpTransport().Send(ip, cmd)
+	c.countCommand(start, err)
+	return err
+}
+
+// brickIP finds a brick's address from any of its devices
+func (c *Client) brickIP(brickID int) (net.IP, error) {
+
+	for _, dev := range c.Devices.ByBrick(brickID) {
+		if dev.IP != nil {
+			return dev.IP, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: no devices on brick %d", ErrUnknownDevice, brickID)
+}
@@ -0,0 +1,260 @@
+package webbrick
+
+import (
+	"errors"  // For crafting our own errors
+	"fmt"     // For printing dry runs
+	"io"      // For where dry runs go
+	"net"     // For the brick's address
+	"os"      // For printing dry runs
+	"strconv" // For naming what has a bad name
+	"strings" // For checking names
+)
+
+// configBatch is how many config commands go in one hid.spi request, to keep the URLs short
+const configBatch = 8
+
+// MaxNameLength is the longest name a brick keeps, for itself or any of its inputs and outputs
+const MaxNameLength = 10
+
+// ErrBadName is returned for a name the brick can't take. Names go in the ; separated
+// command text, inside a batch wrapped in :, so they can't have either in them
+var ErrBadName = errors.New("bad name")
+
+// ConfigFields picks which of a config's single settings (name, SF and mimics) get written.
+// A zero value can't be told from one that wasn't filled in, so they're only written when
+// asked for. The numbered items (SR, CD, CT etc.) don't need it, only the ones in want are sent
+type ConfigFields int
+
+const (
+	FieldName ConfigFields = 1 << iota // NN, the brick's name
+	FieldSF                            // SF
+	FieldMM                            // MM, the mimics
+
+	AllFields = FieldName | FieldSF | FieldMM // For a whole config, e.g. one fetched from a brick
+)
+
+// ApplyOptions control how a config is written to a brick
+type ApplyOptions struct {
+	DryRun bool         // Print the commands rather than sending them
+	Full   bool         // Send everything, not just what differs from the brick's current config
+	Fields ConfigFields // The single settings in want, none of them if 0
+	Out    io.Writer    // Where dry runs print to, defaults to os.Stdout
+}
+
+// ConfigCommands works out the commands that turn the have config into want. With a nil
+// have, everything in want is sent. Name, SF and MM are only looked at if they're in fields.
+// The brick's number, address and firmware version belong to the hardware, so they are never written.
+// Any name that would be sent is checked first, and ErrBadName returned if the brick can't take it
+func ConfigCommands(have *WebbrickConfig, want WebbrickConfig, fields ConfigFields) (*Command, error) {
+
+	cmd := NewCommand()
+	full := have == nil
+	if full {
+		have = &WebbrickConfig{}
+	}
+
+	if fields&FieldName != 0 && (full || want.Name != have.Name) {
+		if err := checkName("brick", want.Name); err != nil {
+			return nil, err
+		}
+		cmd.SetNodeName(want.Name)
+	}
+
+	haveSR := make(map[int]SR)
+	for _, sr := range have.SRs.SR {
+		haveSR[sr.Id] = sr
+	}
+	for _, sr := range want.SRs.SR {
+		if old, ok := haveSR[sr.Id]; !ok || old != sr {
+			cmd.ConfigSR(sr)
+		}
+	}
+
+	if fields&FieldSF != 0 && (full || want.SF != have.SF) {
+		cmd.ConfigSF(want.SF)
+	}
+
+	haveCD := make(map[int]CD)
+	for _, cd := range have.CDs.CD {
+		haveCD[cd.Id] = cd
+	}
+	for _, cd := range want.CDs.CD {
+		if old, ok := haveCD[cd.Id]; !ok || old != cd {
+			if err := checkName("digital input "+strconv.Itoa(cd.Id), cd.Name); err != nil {
+				return nil, err
+			}
+			cmd.ConfigDigitalInput(cd)
+		}
+	}
+
+	haveCC := make(map[int]CC)
+	for _, cc := range have.CCs.CC {
+		haveCC[cc.Id] = cc
+	}
+	for _, cc := range want.CCs.CC {
+		if old, ok := haveCC[cc.Id]; !ok || old != cc {
+			cmd.ConfigScene(cc)
+		}
+	}
+
+	haveCW := make(map[int]CW)
+	for _, cw := range have.CWs.CW {
+		haveCW[cw.Id] = cw
+	}
+	for _, cw := range want.CWs.CW {
+		if old, ok := haveCW[cw.Id]; !ok || old != cw {
+			cmd.ConfigDwell(cw)
+		}
+	}
+
+	haveCS := make(map[int]CS)
+	for _, cs := range have.CSs.CS {
+		haveCS[cs.Id] = cs
+	}
+	for _, cs := range want.CSs.CS {
+		if old, ok := haveCS[cs.Id]; !ok || old != cs {
+			cmd.SetPoint(cs.Id, cs.Value)
+		}
+	}
+
+	haveCT := make(map[int]CT)
+	for _, ct := range have.CTs.CT {
+		haveCT[ct.Id] = ct
+	}
+	for _, ct := range want.CTs.CT {
+		if old, ok := haveCT[ct.Id]; !ok || old != ct {
+			if err := checkName("temperature "+strconv.Itoa(ct.Id), ct.Name); err != nil {
+				return nil, err
+			}
+			cmd.ConfigTemperature(ct)
+		}
+	}
+
+	haveCI := make(map[int]CI)
+	for _, ci := range have.CIs.CI {
+		haveCI[ci.Id] = ci
+	}
+	for _, ci := range want.CIs.CI {
+		if old, ok := haveCI[ci.Id]; !ok || old != ci {
+			if err := checkName("analogue input "+strconv.Itoa(ci.Id), ci.Name); err != nil {
+				return nil, err
+			}
+			cmd.ConfigAnalogueInput(ci)
+		}
+	}
+
+	haveCE := make(map[int]CE)
+	for _, ce := range have.CEs.CE {
+		haveCE[ce.Id] = ce
+	}
+	for _, ce := range want.CEs.CE {
+		if old, ok := haveCE[ce.Id]; !ok || old != ce {
+			cmd.ConfigSchedule(ce)
+		}
+	}
+
+	haveNO := make(map[int]NO)
+	for _, no := range have.NOs.NO {
+		haveNO[no.Id] = no
+	}
+	for _, no := range want.NOs.NO {
+		if old, ok := haveNO[no.Id]; !ok || old != no {
+			if err := checkName("digital output "+strconv.Itoa(no.Id), no.Name); err != nil {
+				return nil, err
+			}
+			cmd.NameDigitalOutput(no)
+		}
+	}
+
+	haveNA := make(map[int]NA)
+	for _, na := range have.NAs.NA {
+		haveNA[na.Id] = na
+	}
+	for _, na := range want.NAs.NA {
+		if old, ok := haveNA[na.Id]; !ok || old != na {
+			if err := checkName("analogue output "+strconv.Itoa(na.Id), na.Name); err != nil {
+				return nil, err
+			}
+			cmd.NameAnalogueOutput(na)
+		}
+	}
+
+	if fields&FieldMM != 0 && (full || want.MM != have.MM) {
+		cmd.ConfigMimics(want.MM)
+	}
+
+	return cmd, nil
+}
+
+// checkName makes sure the brick can take name for what, e.g. "digital input 3"
+func checkName(what string, name string) error {
+	if strings.ContainsAny(name, ";:") {
+		return fmt.Errorf("%w: %s name %q can't have a ; or : in it", ErrBadName, what, name)
+	}
+	if len(name) > MaxNameLength {
+		return fmt.Errorf("%w: %s name %q is longer than %d characters", ErrBadName, what, name, MaxNameLength)
+	}
+	return nil
+}
+
+// ApplyConfig writes a config to one of the bricks we know about
+func (c *Client) ApplyConfig(brickID int, want WebbrickConfig, opts ApplyOptions) (*Command, error) {
+
+	ip, err := c.brickIP(brickID)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.ApplyConfigTo(ip, want, opts)
+}
+
+// ApplyConfigTo writes a config to the brick at ip. Unless opts.Full is set the brick's
+// current config is fetched first and only what differs is sent. The commands are
+// returned, whether they were sent or it was a dry run. Nothing is sent if a name is bad
+func (c *Client) ApplyConfigTo(ip net.IP, want WebbrickConfig, opts ApplyOptions) (*Command, error) {
+
+	var have *WebbrickConfig
+	if !opts.Full {
+		current, err := c.FetchConfig(ip)
+		if err != nil {
+			return nil, err
+		}
+		have = &current
+	}
+
+	cmd, err := ConfigCommands(have, want, opts.Fields)
+	if err != nil {
+		return nil, err
+	}
+	coms := cmd.Coms()
+
+	if opts.DryRun {
+		out := opts.Out
+		if out == nil {
+			out = os.Stdout
+		}
+		for _, com := range coms {
+			fmt.Fprintf(out, "%s: %s\n", ip, com)
+		}
+		return cmd, nil
+	}
+
+	myLog.Infof("   **** Writing %d config commands to %s", len(coms), ip)
+
+	for start := 0; start < len(coms); start += configBatch {
+		end := start + configBatch
+		if end > len(coms) {
+			end = len(coms)
+		}
+
+		batch := NewCommand()
+		for _, com := range coms[start:end] {
+			batch.Raw(com)
+		}
+		if err := c.httpTransport().Send(ip, batch); err != nil {
+			return cmd, fmt.Errorf("writing config to %s after %d of %d commands: %w", ip, start, len(coms), err)
+		}
+	}
+
+	return cmd, nil
+}
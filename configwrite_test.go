@@ -0,0 +1,104 @@
+package webbrick
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// brickConfig is a config as it might come back from a brick
+func brickConfig() WebbrickConfig {
+	var cfg WebbrickConfig
+	cfg.Name = "Hall"
+	cfg.SF = 3
+	cfg.MM = MM{Lo: 10, Hi: 90, Fade: 4}
+	cfg.CDs.CD = []CD{
+		{Id: 0, Name: "Front", Trg: Trg{4, 0, 0, 0}},
+		{Id: 1, Name: "Back", Trg: Trg{4, 1, 0, 0}},
+	}
+	cfg.CWs.CW = []CW{{Id: 0, Value: 30}}
+	return cfg
+}
+
+func TestConfigCommands(t *testing.T) {
+
+	have := brickConfig()
+
+	rename := WebbrickConfig{Name: "Landing"}
+
+	oneInput := WebbrickConfig{}
+	oneInput.CDs.CD = []CD{{Id: 1, Name: "Garden", Trg: Trg{4, 1, 0, 0}}}
+
+	changed := brickConfig()
+	changed.SF = 0
+	changed.MM.Fade = 8
+	changed.CWs.CW[0].Value = 60
+
+	tests := []struct {
+		name   string
+		have   *WebbrickConfig
+		want   WebbrickConfig
+		fields ConfigFields
+		coms   []string
+	}{
+		{"same", &have, brickConfig(), AllFields, nil},
+		{"partial leaves name, SF and mimics alone", &have, oneInput, 0, []string{"CD1;Garden;0;4;1;0;0"}},
+		{"name only", &have, rename, FieldName, []string{"NNLanding"}},
+		{"name only, unchanged", &have, WebbrickConfig{Name: "Hall"}, FieldName, nil},
+		{"unasked for fields", &have, changed, FieldName, []string{"CW0;60"}},
+		{"changes", &have, changed, AllFields, []string{"SF0", "CW0;60", "MM;10;90;0;0;8"}},
+		{"full", nil, rename, AllFields, []string{"NNLanding", "SF0", "MM;0;0;0;0;0"}},
+		{"full, name only", nil, rename, FieldName, []string{"NNLanding"}},
+	}
+
+	for _, tt := range tests {
+		cmd, err := ConfigCommands(tt.have, tt.want, tt.fields)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		got := cmd.Coms()
+		if len(got) == 0 && len(tt.coms) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.coms) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.coms)
+		}
+	}
+}
+
+func TestConfigCommandsBadNames(t *testing.T) {
+
+	have := brickConfig()
+
+	semicolon := WebbrickConfig{}
+	semicolon.CDs.CD = []CD{{Id: 1, Name: "Gate;4", Trg: Trg{4, 1, 0, 0}}}
+
+	colon := WebbrickConfig{}
+	colon.NOs.NO = []NO{{Id: 2, Name: "Hall:Lamp"}}
+
+	long := WebbrickConfig{}
+	long.NAs.NA = []NA{{Id: 0, Name: "Sitting Room"}}
+
+	tests := []struct {
+		name   string
+		want   WebbrickConfig
+		fields ConfigFields
+	}{
+		{"semicolon", semicolon, 0},
+		{"colon", colon, 0},
+		{"too long", long, 0},
+		{"brick", WebbrickConfig{Name: "Hall;RB"}, FieldName},
+	}
+
+	for _, tt := range tests {
+		if cmd, err := ConfigCommands(&have, tt.want, tt.fields); !errors.Is(err, ErrBadName) {
+			t.Errorf("%s: got %v and %v, want ErrBadName", tt.name, cmd, err)
+		}
+	}
+
+	// A bad name we're not sending doesn't matter
+	if _, err := ConfigCommands(&have, WebbrickConfig{Name: "Hall;RB"}, 0); err != nil {
+		t.Errorf("unsent name: %v", err)
+	}
+}
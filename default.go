@@ -0,0 +1,152 @@
+package webbrick
+
+import (
+	"context" // For stopping the receive loop
+)
+
+// The package level functions drive a default Client, so code written against the
+// original globals keeps working. New code should use NewClient instead
+
+var defaultClient = NewClient(nil)
+
+var Events = defaultClient.Events   // Events is the default client's events channel
+var Devices = defaultClient.Devices // All the Devices the default client has discovered
+
+// Prepare sets the default client up from the config, as NewClient would, and gets its UDP
+// connection ready. DEBUG and POLL still turn debugging and polling on, and UDPPort is
+// used if the config doesn't give a port
+func Prepare(wbdc *WebbrickDriverConfig) (bool, error) {
+
+	if wbdc == nil {
+		wbdc = defaultClient.Config
+	}
+	defaultClient.configure(wbdc)
+	defaultClient.Debug = DEBUG || defaultClient.Debug
+
+	return defaultClient.Prepare()
+}
+
+// ListDevices spews out info about all the Devices the default client knows about
+func ListDevices() {
+	defaultClient.ListDevices()
+}
+
+// CheckForMessages checks for incoming UDP messages on the default client
+func CheckForMessages() (bool, error) {
+	return defaultClient.CheckForMessages()
+}
+
+// Run reads UDP messages for the default client until ctx is cancelled
+func Run(ctx context.Context) error {
+	return defaultClient.Run(ctx)
+}
+
+// Close shuts the default client's UDP connection
+func Close() error {
+	return defaultClient.Close()
+}
+
+// PollWBStatus polls a brick's status and config on the default client
+func PollWBStatus(devID string) (int, error) {
+	return defaultClient.PollWBStatus(devID)
+}
+
+// GetWBStatus gets a brick's status and config on the default client
+func GetWBStatus(devID string) (int, error) {
+	return defaultClient.GetWBStatus(devID)
+}
+
+// CreateBrickDevices maps a brick's config and status into the default client's devices
+func CreateBrickDevices(_wbc WebbrickConfig, _wbs WebbrickStatus) (int, error) {
+	return defaultClient.CreateBrickDevices(_wbc, _wbs)
+}
+
+// ToggleState toggles a device on the default client
+func ToggleState(devID string) (bool, error) {
+	return defaultClient.ToggleState(devID)
+}
+
+// SetLightLevel sets a light's level on the default client
+func SetLightLevel(devID string, level float64) (bool, error) {
+	return defaultClient.SetLightLevel(devID, level)
+}
+
+// SetState sets the state of a device on the default client
+func SetState(devID string, state bool) (bool, error) {
+	return defaultClient.SetState(devID, state)
+}
+
+// PushButton pushes a button on the default client
+func PushButton(devID string) (bool, error) {
+	return defaultClient.PushButton(devID)
+}
+
+// GetState gets the state of a device on the default client
+func GetState(devID string) (bool, error) {
+	return defaultClient.GetState(devID)
+}
+
+// GetLevel gets the level of a device on the default client
+func GetLevel(devID string) (float64, error) {
+	return defaultClient.GetLevel(devID)
+}
+
+// GetLastMessage gets the last message for a device on the default client
+func GetLastMessage(devID string) (string, error) {
+	return defaultClient.GetLastMessage(devID)
+}
+
+// ApplyConfig writes a config to a brick on the default client
+func ApplyConfig(brickID int, want WebbrickConfig, opts ApplyOptions) (*Command, error) {
+	return defaultClient.ApplyConfig(brickID, want, opts)
+}
+
+// BackupAll snapshots every brick on the default client into dir
+func BackupAll(dir string, format SnapshotFormat) ([]string, error) {
+	return defaultClient.BackupAll(dir, format)
+}
+
+// StartPolling keeps polling a brick on the default client
+func StartPolling(devID string) error {
+	return defaultClient.StartPolling(devID)
+}
+
+// StopPolling stops polling a brick on the default client
+func StopPolling(brickID int) bool {
+	return defaultClient.StopPolling(brickID)
+}
+
+// StopAllPolling stops polling every brick on the default client
+func StopAllPolling() {
+	defaultClient.StopAllPolling()
+}
+
+// Health is a brick's health on the default client
+func Health(brickID int) (BrickHealth, error) {
+	return defaultClient.Health(brickID)
+}
+
+// Subscribe starts a stream of events from the default client
+func Subscribe(filter EventFilter) (<-chan EventStruct, func()) {
+	return defaultClient.Subscribe(filter)
+}
+
+// Discover looks for bricks on subnet with the default client
+func Discover(ctx context.Context, subnet string) ([]DiscoveredBrick, error) {
+	return defaultClient.Discover(ctx, subnet)
+}
+
+// RefreshBrick fetches a brick's status and config now on the default client
+func RefreshBrick(brickID int) error {
+	return defaultClient.RefreshBrick(brickID)
+}
+
+// DecommissionBrick forgets a brick on the default client
+func DecommissionBrick(brickID int) ([]Device, error) {
+	return defaultClient.DecommissionBrick(brickID)
+}
+
+// BrickConfig is the last WbCfg.xml fetched from a brick on the default client
+func BrickConfig(brickID int) (WebbrickConfig, error) {
+	return defaultClient.BrickConfig(brickID)
+}
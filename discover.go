@@ -0,0 +1,294 @@
+package webbrick
+
+import (
+	"context"                                // For cancelling discovery
+	"errors"                                 // For crafting our own errors
+	"fmt"                                    // For wrapping errors
+	"github.com/paulcull/go-webbrick/packet" // For the attention packet
+	"net"                                    // For walking the subnet
+	"sort"                                   // For stable results
+	"strconv"                                // For String construction
+	"sync"                                   // For the probe workers
+	"time"                                   // For probe timeouts
+)
+
+// ErrSubnetTooBig is returned when asked to probe more than a /16
+var ErrSubnetTooBig = errors.New("subnet too big to probe")
+
+// Defaults for DiscoverOptions
+const (
+	defaultProbeConcurrency = 32
+	defaultProbeTimeout     = 2 * time.Second
+	defaultBroadcastWait    = 2 * time.Second
+)
+
+// DiscoverOptions say how Discover looks for bricks
+type DiscoverOptions struct {
+	Broadcast     bool          // Broadcast an attention packet and probe whoever answers. Needs Prepare and Run
+	Probe         bool          // Probe every address in the subnet for WbStatus.xml
+	Concurrency   int           // How many probes at once, default 32
+	Timeout       time.Duration // How long each probe gets, default 2s
+	BroadcastWait time.Duration // How long to wait for answers to the broadcast, default 2s
+}
+
+// DiscoveredBrick is a brick Discover found, from its status and config
+type DiscoveredBrick struct {
+	BrickNo  int
+	Name     string
+	IP       net.IP
+	MAC      string
+	Firmware string
+}
+
+// Discover looks for bricks on subnet (a CIDR, e.g. "10.100.100.0/24") by broadcasting
+// and by probing every address. See DiscoverWith
+func (c *Client) Discover(ctx context.Context, subnet string) ([]DiscoveredBrick, error) {
+	return c.DiscoverWith(ctx, subnet, DiscoverOptions{Broadcast: true, Probe: true})
+}
+
+// DiscoverWith looks for bricks on subnet. Every brick found has its status and config
+// fetched, and its devices added just as if it had sent us a heartbeat, so quiet bricks
+// show up without waiting for their next broadcast
+func (c *Client) DiscoverWith(ctx context.Context, subnet string, opts DiscoverOptions) ([]DiscoveredBrick, error) {
+
+	_, ipnet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return nil, err
+	}
+	if ipnet.IP.To4() == nil {
+		return nil, fmt.Errorf("%s: only IPv4 subnets are supported", subnet)
+	}
+	if ones, bits := ipnet.Mask.Size(); bits-ones > 16 {
+		return nil, fmt.Errorf("%w: %s", ErrSubnetTooBig, subnet)
+	}
+
+	var targets []net.IP
+
+	if opts.Broadcast {
+		heard, err := c.broadcastAttention(ctx, ipnet, opts)
+		if err != nil {
+			if !opts.Probe {
+				return nil, err
+			}
+			myLog.Warningf("   **** Discovery broadcast failed, probing only: %v", err)
+		}
+		targets = append(targets, heard...)
+	}
+
+	if opts.Probe {
+		targets = append(targets, hosts(ipnet)...)
+	}
+
+	found := c.probe(ctx, dedupe(targets), opts)
+
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].BrickNo != found[j].BrickNo {
+			return found[i].BrickNo < found[j].BrickNo
+		}
+		return found[i].IP.String() < found[j].IP.String()
+	})
+
+	return found, ctx.Err()
+}
+
+// broadcastAttention sends an NN packet to the subnet's broadcast address and hands back
+// the addresses we hear from while waiting. Run picks the answers up off our UDP port
+func (c *Client) broadcastAttention(ctx context.Context, ipnet *net.IPNet, opts DiscoverOptions) ([]net.IP, error) {
+
+	conn := c.udpConn()
+	if conn == nil {
+		return nil, ErrNotPrepared
+	}
+
+	buf, err := packet.Encode(packet.Packet{Class: packet.ClassGeneral, Type: packet.TypeAttention})
+	if err != nil {
+		return nil, err
+	}
+
+	since := time.Now()
+	bcast := broadcastAddr(ipnet)
+	if _, err := conn.WriteToUDP(buf, &net.UDPAddr{IP: bcast, Port: CommandPort}); err != nil {
+		return nil, err
+	}
+	myLog.Infof("   **** Sent attention to %s", bcast)
+
+	wait := opts.BroadcastWait
+	if wait <= 0 {
+		wait = defaultBroadcastWait
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(wait):
+	}
+
+	return c.heardSince(since, ipnet), nil
+}
+
+// probe fetches the status and config from every target, a few at a time
+func (c *Client) probe(ctx context.Context, targets []net.IP, opts DiscoverOptions) []DiscoveredBrick {
+
+	workers := opts.Concurrency
+	if workers <= 0 {
+		workers = defaultProbeConcurrency
+	}
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = defaultProbeTimeout
+	}
+
+	jobs := make(chan net.IP)
+	var mu sync.Mutex
+	var found []DiscoveredBrick
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ip := range jobs {
+				if brick, ok := c.probeOne(ctx, ip, timeout); ok {
+					mu.Lock()
+					found = append(found, brick)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, ip := range targets {
+		select {
+		case <-ctx.Done():
+			break feed
+		case jobs <- ip:
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	return found
+}
+
+// probeOne checks a single address for a brick, and adds it if there is one
+func (c *Client) probeOne(ctx context.Context, ip net.IP, timeout time.Duration) (DiscoveredBrick, bool) {
+
+	pctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	_wbs, err := c.FetchStatusContext(pctx, ip)
+	if err != nil {
+		return DiscoveredBrick{}, false // Nothing there, or not a brick
+	}
+	_wbc, err := c.FetchConfigContext(pctx, ip)
+	if err != nil {
+		myLog.Warningf("   **** %s has a status page but no config: %v", ip, err)
+		return DiscoveredBrick{}, false
+	}
+
+	brick := DiscoveredBrick{
+		BrickNo:  _wbs.BrickNo,
+		Name:     _wbc.Name,
+		IP:       ip,
+		MAC:      _wbc.IP.MACString,
+		Firmware: _wbc.Version,
+	}
+	if brick.Firmware == "" {
+		brick.Firmware = _wbs.Version
+	}
+	myLog.Infof("   **** Discovered brick %d (%s) at %s", brick.BrickNo, brick.Name, ip)
+
+	c.addDiscovered(brick, _wbc, _wbs)
+	return brick, true
+}
+
+// addDiscovered sets a found brick up as if it had sent us a heartbeat and been polled
+func (c *Client) addDiscovered(brick DiscoveredBrick, _wbc WebbrickConfig, _wbs WebbrickStatus) {
+
+	UID := strconv.Itoa(brick.BrickNo) + "::" + string(packet.TypeTime) + "::0"
+	_message := "Discovered at " + brick.IP.String()
+
+	prev, unknown := c.Devices.Get(UID)
+	if unknown != nil {
+		dev, _ := c.Devices.add(Device{0, UID, brick.Name, brick.BrickNo, HEARTBEAT, 0, brick.IP, true, false, false, 0, _message})
+		c.passMessage("newwebbrickfound", EventDiscovered, SourceDiscovery, prev, dev, nil)
+	} else {
+		dev, _ := c.Devices.update(UID, func(d *Device) {
+			d.IP = brick.IP
+			d.LastMessage = _message
+		})
+		c.passMessage("existingwebbrickupdated", EventUpdated, SourceDiscovery, prev, dev, nil)
+	}
+
+	c.storeBrick(_wbc, _wbs)
+	c.pollSucceeded(brick.BrickNo)
+	if _, err := c.CreateBrickDevices(_wbc, _wbs); err != nil {
+		myLog.Errorf("error mapping devices %v", err)
+	}
+}
+
+// noteSender remembers who we've heard from, so a discovery broadcast can tell who answered
+func (c *Client) noteSender(ip net.IP) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.heard[ip.String()] = time.Now()
+}
+
+// heardSince are the addresses in ipnet we've heard from since a time
+func (c *Client) heardSince(since time.Time, ipnet *net.IPNet) []net.IP {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var ips []net.IP
+	for addr, at := range c.heard {
+		if ip := net.ParseIP(addr); ip != nil && !at.Before(since) && ipnet.Contains(ip) {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// hosts are the usable addresses in an IPv4 subnet, leaving out the network and
+// broadcast addresses unless it's a /31 or /32
+func hosts(ipnet *net.IPNet) []net.IP {
+
+	base := ipnet.IP.Mask(ipnet.Mask).To4()
+	ones, bits := ipnet.Mask.Size()
+	size := uint32(1) << uint(bits-ones)
+	start := uint32(base[0])<<24 | uint32(base[1])<<16 | uint32(base[2])<<8 | uint32(base[3])
+
+	first, last := uint32(0), size-1
+	if size > 2 {
+		first, last = 1, size-2
+	}
+
+	ips := make([]net.IP, 0, last-first+1)
+	for i := first; i <= last; i++ {
+		n := start + i
+		ips = append(ips, net.IPv4(byte(n>>24), byte(n>>16), byte(n>>8), byte(n)).To4())
+	}
+	return ips
+}
+
+// broadcastAddr is the subnet's broadcast address
+func broadcastAddr(ipnet *net.IPNet) net.IP {
+	base := ipnet.IP.Mask(ipnet.Mask).To4()
+	bcast := make(net.IP, len(base))
+	for i := range base {
+		bcast[i] = base[i] | ^ipnet.Mask[len(ipnet.Mask)-len(base)+i]
+	}
+	return bcast
+}
+
+// dedupe drops repeated addresses, keeping the first
+func dedupe(ips []net.IP) []net.IP {
+	seen := make(map[string]bool, len(ips))
+	out := ips[:0:0]
+	for _, ip := range ips {
+		if key := ip.String(); !seen[key] {
+			seen[key] = true
+			out = append(out, ip)
+		}
+	}
+	return out
+}
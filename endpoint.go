@@ -0,0 +1,84 @@
+package webbrick
+
+import (
+	"context"  // For cancelling requests
+	"net"      // For the brick's address
+	"net/http" // For building requests
+	"strconv"  // For the gateway port
+	"strings"  // For tidying the gateway prefix
+)
+
+// Endpoint works out how to reach a brick's web server. path is the page on the brick,
+// with any query, e.g. "/WbStatus.xml" or "/hid.spi?com=..."
+type Endpoint interface {
+	NewRequest(ctx context.Context, ip net.IP, path string) (*http.Request, error)
+}
+
+// Direct talks to the bricks straight, for when we're on the same network
+type Direct struct{}
+
+// NewRequest builds a GET for http://<ip><path>
+func (Direct) NewRequest(ctx context.Context, ip net.IP, path string) (*http.Request, error) {
+	return http.NewRequestWithContext(ctx, http.MethodGet, "http://"+ip.String()+path, nil)
+}
+
+// Gateway goes through a reverse proxy that passes <prefix>/<ip>/<page> on to the brick,
+// for reaching the bricks from outside the local network
+type Gateway struct {
+	Host     string      // e.g. "home.example.com"
+	Port     int         // 0 for the scheme's default
+	HTTPS    bool        // Use https to the gateway
+	Prefix   string      // Path the proxy lives under, defaults to "/wbproxy"
+	Username string      // Basic auth, if set
+	Password string      // Basic auth
+	Header   http.Header // Sent on every request, e.g. an API key
+}
+
+// NewRequest builds a GET for the page through the gateway
+func (g *Gateway) NewRequest(ctx context.Context, ip net.IP, path string) (*http.Request, error) {
+
+	scheme := "http://"
+	if g.HTTPS {
+		scheme = "https://"
+	}
+	host := g.Host
+	if g.Port != 0 {
+		host = net.JoinHostPort(g.Host, strconv.Itoa(g.Port))
+	}
+	prefix := g.Prefix
+	if prefix == "" {
+		prefix = "/wbproxy"
+	}
+	prefix = "/" + strings.Trim(prefix, "/")
+
+	// e.g. http://home.example.com:8080/wbproxy/10.100.100.101/WbStatus.xml
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, scheme+host+prefix+"/"+ip.String()+path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, values := range g.Header {
+		for _, v := range values {
+			req.Header.Add(key, v)
+		}
+	}
+	if g.Username != "" {
+		req.SetBasicAuth(g.Username, g.Password)
+	}
+
+	return req, nil
+}
+
+// SetRoundTripper swaps out how the HTTP requests are made, e.g. for a tunnel or a test
+// double. A nil one goes back to the default
+func (c *Client) SetRoundTripper(rt http.RoundTripper) {
+	c.httpClient.Transport = rt
+}
+
+// endpoint is how to reach the bricks, direct unless told otherwise
+func (c *Client) endpoint() Endpoint {
+	if c.Endpoint == nil {
+		return Direct{}
+	}
+	return c.Endpoint
+}
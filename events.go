@@ -0,0 +1,127 @@
+package webbrick
+
+import (
+	"fmt"                                    // For unknown kinds
+	"github.com/paulcull/go-webbrick/packet" // For the packet behind an event
+	"strconv"                                // For parsing kinds
+	"strings"                                // For parsing kinds
+	"time"                                   // For stamping events
+)
+
+// EventKind is what happened to the device in an event. Together with the device's
+// Type it says e.g. "a light was discovered" or "a button was commanded"
+type EventKind int
+
+const (
+	EventUnknown    EventKind = iota
+	EventDiscovered           // First time we've seen the device
+	EventUpdated              // The device reported in, from a packet or a poll
+	EventCommanded            // We sent the device a command
+	EventOffline              // The brick has gone quiet, see BrickHealth
+	EventOnline               // The brick is back
+	EventRemoved              // The device's brick was decommissioned
+)
+
+// EventSource is where an event came from
+type EventSource int
+
+const (
+	SourceUnknown   EventSource = iota
+	SourceUDP                   // A packet from a brick
+	SourcePoll                  // Fetching WbStatus.xml and WbCfg.xml
+	SourceCommand               // A command we sent
+	SourceHealth                // The health watchdog
+	SourceDiscovery             // Discover probing the network
+)
+
+// DeviceValue is the part of a device that changes
+type DeviceValue struct {
+	State bool
+	Level float64
+}
+
+var eventKindNames = map[EventKind]string{
+	EventUnknown:    "unknown",
+	EventDiscovered: "discovered",
+	EventUpdated:    "updated",
+	EventCommanded:  "commanded",
+	EventOffline:    "offline",
+	EventOnline:     "online",
+	EventRemoved:    "removed",
+}
+
+var eventSourceNames = map[EventSource]string{
+	SourceUnknown:   "unknown",
+	SourceUDP:       "udp",
+	SourcePoll:      "poll",
+	SourceCommand:   "command",
+	SourceHealth:    "health",
+	SourceDiscovery: "discovery",
+}
+
+func (k EventKind) String() string {
+	if name, ok := eventKindNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("kind(%d)", int(k))
+}
+
+// MarshalText puts the name rather than the number in JSON
+func (k EventKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// ParseEventKind takes a kind name ("updated", "Removed") or number ("2") back to the kind
+func ParseEventKind(s string) (EventKind, error) {
+
+	if n, err := strconv.Atoi(s); err == nil {
+		if _, ok := eventKindNames[EventKind(n)]; ok && EventKind(n) != EventUnknown {
+			return EventKind(n), nil
+		}
+		return EventUnknown, fmt.Errorf("unknown event kind %d", n)
+	}
+
+	for kind, name := range eventKindNames {
+		if kind != EventUnknown && strings.EqualFold(name, s) {
+			return kind, nil
+		}
+	}
+	return EventUnknown, fmt.Errorf("unknown event kind %q", s)
+}
+
+func (s EventSource) String() string {
+	if name, ok := eventSourceNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("source(%d)", int(s))
+}
+
+// MarshalText puts the name rather than the number in JSON
+func (s EventSource) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// valueOf is the changing part of a device
+func valueOf(d Device) DeviceValue {
+	return DeviceValue{State: d.State, Level: d.Level}
+}
+
+// passMessage raises an event. message is the original free form name, which older code
+// still matches on. prev is the device before the change, empty for new devices
+func (c *Client) passMessage(message string, kind EventKind, source EventSource, prev Device, device Device, pkt *packet.Packet) bool {
+
+	if source == SourceUDP && (device.Type == PIR || device.Type == BUTTON) {
+		c.countTrigger(device.DevID)
+	}
+
+	return c.passEvent(EventStruct{
+		Name:       message,
+		DeviceInfo: device,
+		Kind:       kind,
+		Previous:   valueOf(prev),
+		Current:    valueOf(device),
+		Time:       time.Now(),
+		Source:     source,
+		Packet:     pkt,
+	})
+}
@@ -0,0 +1,69 @@
+package webbrick
+
+import (
+	"net"
+	"testing"
+)
+
+func TestPacketEvents(t *testing.T) {
+
+	c := NewClient(&WebbrickDriverConfig{})
+	from := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 2)}
+
+	tests := []struct {
+		name     string
+		buf      []byte
+		kind     EventKind
+		typ      int
+		previous DeviceValue
+		current  DeviceValue
+	}{
+		{
+			"new light", []byte{13, 'G', 'A', 'O', 1, 0, 0, 2, 0, 0, 0, 40, 0},
+			EventDiscovered, LIGHT, DeviceValue{}, DeviceValue{State: true, Level: 40},
+		},
+		{
+			"light dimmed", []byte{13, 'G', 'A', 'O', 1, 0, 0, 2, 0, 0, 0, 75, 0},
+			EventUpdated, LIGHT, DeviceValue{State: true, Level: 40}, DeviceValue{State: true, Level: 75},
+		},
+		{
+			"light off", []byte{13, 'G', 'A', 'O', 1, 0, 0, 2, 0, 0, 0, 0, 0},
+			EventUpdated, LIGHT, DeviceValue{State: true, Level: 75}, DeviceValue{State: false, Level: 0},
+		},
+		{
+			"new temperature", []byte{13, 'G', 'C', 'T', 0, 0, 0, 2, 0, 0, 0, 0x01, 0x59},
+			EventDiscovered, TEMP, DeviceValue{}, DeviceValue{Level: 21.5625},
+		},
+		{
+			"colder", []byte{13, 'G', 'C', 'T', 0, 0, 0, 2, 0, 0, 0, 0xFF, 0xD8},
+			EventUpdated, TEMP, DeviceValue{Level: 21.5625}, DeviceValue{Level: -2.5},
+		},
+		{
+			"new brick", []byte{13, 'G', 'S', 'T', 14, 35, 20, 2, 0, 3, 0, 0, 0},
+			EventDiscovered, HEARTBEAT, DeviceValue{}, DeviceValue{},
+		},
+	}
+
+	for _, tt := range tests {
+		if _, err := c.handleMessage(tt.buf, from); err != nil {
+			t.Fatalf("%s: handleMessage error: %v", tt.name, err)
+		}
+
+		var ev EventStruct
+		select {
+		case ev = <-c.Events:
+		default:
+			t.Fatalf("%s: no event", tt.name)
+		}
+
+		if ev.Kind != tt.kind || ev.Source != SourceUDP || ev.DeviceInfo.Type != tt.typ {
+			t.Errorf("%s: got %s from %s for type %d, want %s from udp for type %d", tt.name, ev.Kind, ev.Source, ev.DeviceInfo.Type, tt.kind, tt.typ)
+		}
+		if ev.Previous != tt.previous || ev.Current != tt.current {
+			t.Errorf("%s: went from %+v to %+v, want %+v to %+v", tt.name, ev.Previous, ev.Current, tt.previous, tt.current)
+		}
+		if ev.Time.IsZero() || ev.Packet == nil {
+			t.Errorf("%s: event has no time or packet", tt.name)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package webbrick
+
+import (
+	"context" // For stopping the watchdog
+	"fmt"     // For wrapping errors
+	"sort"    // For stable listings
+	"time"    // For tracking silence
+)
+
+// defaultOfflineAfter is how long a brick can go without a heartbeat or a good poll before it is offline
+const defaultOfflineAfter = 5 * time.Minute
+
+// maxPollBackoff caps how far polling backs off while a brick is down
+const maxPollBackoff = time.Hour
+
+// BrickHealth is what we know about whether a brick is up
+type BrickHealth struct {
+	BrickID       int
+	Online        bool
+	LastHeartbeat time.Time // Last ST packet
+	LastPoll      time.Time // Last successful GetWBStatus
+	LastSeen      time.Time // The later of the two
+	Failures      int       // Polls that have failed in a row
+	LastError     error     // Why the last poll failed, nil once one works
+}
+
+// Health is a brick's health
+func (c *Client) Health(brickID int) (BrickHealth, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	h, ok := c.health[brickID]
+	if !ok {
+		return BrickHealth{}, fmt.Errorf("%w: %d", ErrUnknownBrick, brickID)
+	}
+	return *h, nil
+}
+
+// AllHealth is the health of every brick we've heard from, in brick order
+func (c *Client) AllHealth() []BrickHealth {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	list := make([]BrickHealth, 0, len(c.health))
+	for _, h := range c.health {
+		list = append(list, *h)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].BrickID < list[j].BrickID })
+	return list
+}
+
+// heartbeat records an ST packet from a brick
+func (c *Client) heartbeat(brickID int) {
+	c.seen(brickID, func(h *BrickHealth, now time.Time) {
+		h.LastHeartbeat = now
+	})
+}
+
+// pollSucceeded records a good GetWBStatus
+func (c *Client) pollSucceeded(brickID int) {
+	c.countPoll(brickID, nil)
+	c.seen(brickID, func(h *BrickHealth, now time.Time) {
+		h.LastPoll = now
+		h.Failures = 0
+		h.LastError = nil
+	})
+}
+
+// pollFailed records a failed GetWBStatus. It doesn't take the brick offline by itself,
+// that's down to the silence check, but it does make polling back off
+func (c *Client) pollFailed(brickID int, err error) {
+	c.countPoll(brickID, err)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	h := c.brickHealth(brickID)
+	h.Failures++
+	h.LastError = err
+}
+
+// seen records hearing from a brick, and raises brickonline if it had gone offline
+func (c *Client) seen(brickID int, update func(h *BrickHealth, now time.Time)) {
+
+	now := time.Now()
+
+	c.mu.Lock()
+	h := c.brickHealth(brickID)
+	update(h, now)
+	h.LastSeen = now
+	back := !h.Online
+	h.Online = true
+	c.mu.Unlock()
+
+	if back {
+		myLog.Infof("   **** Brick %d is back online", brickID)
+		dev := c.brickDevice(brickID)
+		c.passMessage("brickonline", EventOnline, SourceHealth, dev, dev, nil)
+	}
+}
+
+// brickHealth gets a brick's health record, making it if it's the first we've heard. Hold c.mu
+func (c *Client) brickHealth(brickID int) *BrickHealth {
+	h, ok := c.health[brickID]
+	if !ok {
+		h = &BrickHealth{BrickID: brickID, Online: true}
+		c.health[brickID] = h
+	}
+	return h
+}
+
+// startHealth starts the health watchdog, unless it's already going. Prepare starts it and Close stops it
+func (c *Client) startHealth() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stopHealth != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.stopHealth = cancel
+	go c.watchHealth(ctx)
+}
+
+// watchHealth checks for silent bricks until ctx is cancelled
+func (c *Client) watchHealth(ctx context.Context) {
+
+	every := c.OfflineAfter / 4
+	if every < time.Second {
+		every = time.Second
+	}
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.checkHealth()
+		}
+	}
+}
+
+// checkHealth takes any brick that has been quiet for longer than OfflineAfter offline
+func (c *Client) checkHealth() {
+
+	if c.OfflineAfter <= 0 {
+		return
+	}
+
+	var gone []int
+
+	c.mu.Lock()
+	for brickID, h := range c.health {
+		if h.Online && time.Since(h.LastSeen) > c.OfflineAfter {
+			h.Online = false
+			gone = append(gone, brickID)
+		}
+	}
+	c.mu.Unlock()
+
+	sort.Ints(gone)
+	for _, brickID := range gone {
+		myLog.Warningf("   **** Brick %d has gone offline", brickID)
+		dev := c.brickDevice(brickID)
+		c.passMessage("brickoffline", EventOffline, SourceHealth, dev, dev, nil)
+	}
+}
+
+// backoff stretches a poll delay while a brick's polls are failing, doubling for each failure
+func (c *Client) backoff(brickID int, delay time.Duration) time.Duration {
+
+	c.mu.Lock()
+	failures := 0
+	if h, ok := c.health[brickID]; ok {
+		failures = h.Failures
+	}
+	c.mu.Unlock()
+
+	limit := maxPollBackoff
+	if delay > limit {
+		limit = delay
+	}
+	for ; failures > 0 && delay < limit; failures-- {
+		delay *= 2
+	}
+	if delay > limit {
+		delay = limit
+	}
+	return delay
+}
+
+// brickDevice is the brick's heartbeat device, for events about the brick as a whole
+func (c *Client) brickDevice(brickID int) Device {
+	for _, dev := range c.Devices.ByBrick(brickID) {
+		if dev.Type == HEARTBEAT {
+			return dev
+		}
+	}
+	return Device{BrickID: brickID, Type: HEARTBEAT}
+}
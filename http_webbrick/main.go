@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"                                  // For stopping the webbrick receive loop
+	"flag"                                     // For the settings
+	"fmt"                                      // For outputting messages
+	"github.com/paulcull/go-webbrick"          // For controlling webbrick stuff
+	"github.com/paulcull/go-webbrick/httpapi"  // For the API
+	"github.com/paulcull/go-webbrick/recorder" // For keeping the readings
+	"net/http"                                 // For serving
+	"os"                                       // For OS Interaction
+	"os/signal"                                // For picking up the signal
+	"syscall"                                  // Pick up for when running as systemctl service
+	"time"                                     // For shutting down
+)
+
+////////////////////
+// main proc
+////////////////////
+// 1. reads the flags
+// 2. connects to webbrick library and listens for bricks
+// 3. records the readings, if asked, see recorder
+// 4. serves the JSON API, see httpapi, until told to stop
+////////////////////
+func main() {
+
+	listen := flag.String("listen", ":8080", "address to serve the API on")
+	udpPort := flag.String("udp-port", webbrick.UDPPort, "UDP port the bricks send to")
+	polling := flag.Bool("polling", false, "keep polling the bricks for status")
+	debug := flag.Bool("debug", false, "dump out packets and devices")
+	influxFile := flag.String("record-influx-file", "", "append readings to this file as InfluxDB line protocol")
+	influxURL := flag.String("record-influx-url", "", "post readings to this InfluxDB write URL")
+	influxToken := flag.String("record-influx-token", "", "token for -record-influx-url")
+	csvDir := flag.String("record-csv", "", "write readings to daily CSV files in this directory")
+	interval := flag.Duration("record-interval", 0, "record at most one reading per device this often, 0 for every change")
+	minChange := flag.Float64("record-min-change", 0, "ignore level changes smaller than this")
+	flag.Parse()
+
+	////////////////////
+	// connect to webbrick library
+	wb := webbrick.NewClient(&webbrick.WebbrickDriverConfig{
+		Name:           "PKHome",
+		PollingMinutes: 5,
+		PollingActive:  *polling,
+		UDPPort:        *udpPort,
+		Debug:          *debug,
+	})
+	if ready, err := wb.Prepare(); ready == false {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+
+	////////////////////
+	// go and get all the details of each new webbrick, so its devices show up. Each
+	// fetch gets its own goroutine, a slow brick mustn't hold up the events behind it.
+	// Missing one would leave the brick without devices, so this one blocks rather than drops
+	newBricks, stopNewBricks := wb.Subscribe(webbrick.EventFilter{
+		Types:  []int{webbrick.HEARTBEAT},
+		Kinds:  []webbrick.EventKind{webbrick.EventDiscovered},
+		Policy: webbrick.Block,
+	})
+	defer stopNewBricks()
+	go func() {
+		for msg := range newBricks {
+			go func(devID string) {
+				if _, err := wb.PollWBStatus(devID); err != nil {
+					fmt.Println("Error:", err)
+				}
+			}(msg.DeviceInfo.DevID)
+		}
+	}()
+
+	////////////////////
+	// start reading UDP once we're listening for new bricks, so none get past
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		if err := wb.Run(ctx); err != nil && err != context.Canceled {
+			fmt.Println("Error:", err)
+		}
+	}()
+
+	////////////////////
+	// record the readings, to as many sinks as we've been given
+	var sinks []recorder.Sink
+	if *influxFile != "" {
+		sink, err := recorder.NewInfluxFile(*influxFile)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		sinks = append(sinks, sink)
+	}
+	if *influxURL != "" {
+		sinks = append(sinks, &recorder.InfluxHTTP{URL: *influxURL, Token: *influxToken})
+	}
+	if *csvDir != "" {
+		sinks = append(sinks, &recorder.CSV{Dir: *csvDir})
+	}
+	recording := make(chan error, len(sinks))
+	for _, sink := range sinks {
+		rec := recorder.New(wb, sink, recorder.Options{Interval: *interval, MinChange: *minChange})
+		go func(rec *recorder.Recorder) {
+			recording <- rec.Run(ctx)
+		}(rec)
+	}
+
+	////////////////////
+	// serve the API
+	srv := &http.Server{Addr: *listen, Handler: httpapi.NewHandler(wb)}
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-sigc
+		fmt.Println("******** Shutting down...")
+		shutdown, done := context.WithTimeout(context.Background(), 5*time.Second)
+		defer done()
+		srv.Shutdown(shutdown)
+		cancel()
+		for range sinks { // every recorder writes out what it had
+			<-recording
+		}
+		for _, sink := range sinks { // before any sink goes
+			sink.Close()
+		}
+		wb.Close()
+	}()
+
+	fmt.Println("Serving the webbrick API on", *listen)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	<-stopped // let the tidy up finish
+}
@@ -0,0 +1,347 @@
+package httpapi
+
+import (
+	"bufio"                           // For the hijacked connection
+	"crypto/sha1"                     // For the WebSocket handshake
+	"encoding/base64"                 // For the WebSocket handshake
+	"encoding/binary"                 // For frame lengths
+	"encoding/json"                   // For the events
+	"fmt"                             // For crafting our own errors
+	"github.com/paulcull/go-webbrick" // For controlling webbrick stuff
+	"io"                              // For reading frames
+	"net/http"                        // For serving
+	"strconv"                         // For the filters
+	"strings"                         // For the filters and headers
+	"sync"                            // For guarding the WebSocket writes
+	"time"                            // For keepalives
+)
+
+////////////////////
+// Live events
+////////////////////
+// GET /events streams every event as JSON, as Server-Sent Events, or as WebSocket text
+// messages if the request asks to upgrade. Narrow it down with
+//   ?brick=2,3  ?type=light,pir  ?kind=updated,commanded  (names or numbers, repeat or comma separate)
+// Each stream has its own subscription, so a slow browser only loses its own events,
+// oldest first.
+////////////////////
+
+// streamBuffer is how far a stream can fall behind before it drops events
+const streamBuffer = 100
+
+// keepAlive is how often an idle stream is poked, so proxies don't time it out
+const keepAlive = 30 * time.Second
+
+// wsGUID is from RFC 6455, for working out Sec-WebSocket-Accept
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket opcodes
+const (
+	wsText  = 0x1
+	wsClose = 0x8
+	wsPing  = 0x9
+	wsPong  = 0xA
+)
+
+// wsMaxFrame is the most we'll take from a client, who has nothing to tell us
+const wsMaxFrame = 4096
+
+// events picks SSE or WebSocket and streams
+func (h *Handler) events(w http.ResponseWriter, r *http.Request) {
+
+	filter, err := eventFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		h.webSocket(w, r, filter)
+		return
+	}
+	h.serverSentEvents(w, r, filter)
+}
+
+// eventFilter reads the filter from the query string
+func eventFilter(r *http.Request) (webbrick.EventFilter, error) {
+
+	filter := webbrick.EventFilter{Buffer: streamBuffer, Policy: webbrick.DropOldest}
+	query := r.URL.Query()
+
+	for _, b := range listOf(query["brick"]) {
+		brickID, err := strconv.Atoi(b)
+		if err != nil {
+			return filter, fmt.Errorf("%w: bad brick %q", errBadRequest, b)
+		}
+		filter.Bricks = append(filter.Bricks, brickID)
+	}
+	for _, t := range listOf(query["type"]) {
+		devType, err := webbrick.ParseDeviceType(t)
+		if err != nil {
+			return filter, fmt.Errorf("%w: %v", errBadRequest, err)
+		}
+		filter.Types = append(filter.Types, devType)
+	}
+	for _, k := range listOf(query["kind"]) {
+		kind, err := webbrick.ParseEventKind(k)
+		if err != nil {
+			return filter, fmt.Errorf("%w: %v", errBadRequest, err)
+		}
+		filter.Kinds = append(filter.Kinds, kind)
+	}
+
+	return filter, nil
+}
+
+// listOf splits up repeated and comma separated values
+func listOf(values []string) []string {
+	var list []string
+	for _, v := range values {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
+
+////////////////////
+// Server-Sent Events
+////////////////////
+
+func (h *Handler) serverSentEvents(w http.ResponseWriter, r *http.Request, filter webbrick.EventFilter) {
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("can't stream on this connection"))
+		return
+	}
+
+	events, cancel := h.Client.Subscribe(filter)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // Stop nginx holding on to it
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	tick := time.NewTicker(keepAlive)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-tick.C:
+			if _, err := io.WriteString(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(ev)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Kind, data); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+////////////////////
+// WebSocket
+////////////////////
+// Just enough of RFC 6455 to push text messages: we never fragment, answer pings and
+// closes, and ignore anything else the client sends.
+////////////////////
+
+// wsConn is the hijacked connection, writes from the events and the reader go through it
+type wsConn struct {
+	mu sync.Mutex
+	rw *bufio.ReadWriter
+}
+
+func (h *Handler) webSocket(w http.ResponseWriter, r *http.Request, filter webbrick.EventFilter) {
+
+	key := r.Header.Get("Sec-WebSocket-Key")
+	switch {
+	case !headerHas(r.Header, "Connection", "upgrade"):
+		writeError(w, http.StatusBadRequest, fmt.Errorf("%w: websocket wants Connection: Upgrade", errBadRequest))
+		return
+	case r.Header.Get("Sec-WebSocket-Version") != "13":
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		writeError(w, http.StatusBadRequest, fmt.Errorf("%w: websocket version 13 only", errBadRequest))
+		return
+	case key == "":
+		writeError(w, http.StatusBadRequest, fmt.Errorf("%w: no Sec-WebSocket-Key", errBadRequest))
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("can't upgrade this connection"))
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer conn.Close()
+
+	sum := sha1.Sum([]byte(key + wsGUID))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: %s\r\n\r\n", base64.StdEncoding.EncodeToString(sum[:]))
+	if err := rw.Flush(); err != nil {
+		return
+	}
+
+	events, cancel := h.Client.Subscribe(filter)
+	defer cancel()
+
+	ws := &wsConn{rw: rw}
+	closed := make(chan struct{})
+	go func() {
+		ws.read() // Until the client closes or goes away
+		close(closed)
+	}()
+
+	tick := time.NewTicker(keepAlive)
+	defer tick.Stop()
+
+	for {
+		var err error
+		select {
+		case <-closed:
+			return
+		case <-tick.C:
+			err = ws.write(wsPing, nil)
+		case ev, ok := <-events:
+			if !ok {
+				ws.write(wsClose, nil)
+				return
+			}
+			data, jsonErr := json.Marshal(ev)
+			if jsonErr != nil {
+				continue
+			}
+			err = ws.write(wsText, data)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// read deals with what the client sends, until it closes or there's an error
+func (ws *wsConn) read() {
+
+	for {
+		opcode, payload, err := ws.readFrame()
+		if err != nil {
+			return
+		}
+		switch opcode {
+		case wsClose:
+			ws.write(wsClose, payload)
+			return
+		case wsPing:
+			if ws.write(wsPong, payload) != nil {
+				return
+			}
+		}
+	}
+}
+
+// readFrame reads one frame from the client, which must be masked
+func (ws *wsConn) readFrame() (byte, []byte, error) {
+
+	var head [2]byte
+	if _, err := io.ReadFull(ws.rw, head[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode := head[0] & 0x0F
+	if head[1]&0x80 == 0 {
+		return 0, nil, fmt.Errorf("unmasked frame from client")
+	}
+
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(ws.rw, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(ws.rw, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > wsMaxFrame {
+		return 0, nil, fmt.Errorf("frame of %d bytes is too big", length)
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(ws.rw, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(ws.rw, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	return opcode, payload, nil
+}
+
+// write sends a whole, unmasked, frame
+func (ws *wsConn) write(opcode byte, payload []byte) error {
+
+	head := []byte{0x80 | opcode} // FIN, we never fragment
+	switch n := len(payload); {
+	case n < 126:
+		head = append(head, byte(n))
+	case n <= 0xFFFF:
+		head = append(head, 126, 0, 0)
+		binary.BigEndian.PutUint16(head[2:], uint16(n))
+	default:
+		head = append(head, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(head[2:], uint64(n))
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if _, err := ws.rw.Write(head); err != nil {
+		return err
+	}
+	if _, err := ws.rw.Write(payload); err != nil {
+		return err
+	}
+	return ws.rw.Flush()
+}
+
+// headerHas is true if the comma separated header has the token
+func headerHas(header http.Header, key, token string) bool {
+	for _, v := range header.Values(key) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
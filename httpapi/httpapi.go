@@ -0,0 +1,298 @@
+// Package httpapi serves a webbrick Client over HTTP as JSON, for wall tablets and
+// scripts that want something simpler than MQTT.
+//
+//	GET  /bricks                   the bricks we have configs for, with their health
+//	GET  /bricks/{n}/config        a brick's WbCfg.xml
+//	GET  /devices                  every device, ?brick=2&type=light to narrow it down
+//	GET  /devices/{devID}          one device, e.g. /devices/2::DO::0
+//	POST /devices/{devID}/state    {"state": true}, or {"toggle": true}, lights and outputs only
+//	POST /devices/{devID}/level    {"level": 0-100}, lights only
+//	POST /devices/{devID}/push     push a trigger input, buttons and PIRs only
+//	GET  /events                   live events, as Server-Sent Events or a WebSocket, see events.go
+//	GET  /metrics                  Prometheus metrics, see metrics.go
+//
+// Commands answer with the device as it is afterwards. Errors come back as
+// {"error": "..."}, with 404 for unknown bricks and devices, 400 for bad requests and
+// 502 when the brick didn't take the command.
+package httpapi
+
+import (
+	"encoding/json"                   // For the requests and replies
+	"errors"                          // For picking out the library's errors
+	"fmt"                             // For crafting our own errors
+	"github.com/paulcull/go-webbrick" // For controlling webbrick stuff
+	"net/http"                        // For serving
+	"strconv"                         // For brick numbers and filters
+	"strings"                         // For splitting the path
+	"time"                            // For health times
+)
+
+// maxBody is as much request body as we'll read, commands are tiny
+const maxBody = 4096
+
+// errBadRequest is behind anything the caller got wrong
+var errBadRequest = errors.New("bad request")
+
+// Handler serves the API for a Client
+type Handler struct {
+	Client *webbrick.Client
+}
+
+// NewHandler builds a Handler for the client
+func NewHandler(c *webbrick.Client) *Handler {
+	return &Handler{Client: c}
+}
+
+// Brick is a brick as the API lists it
+type Brick struct {
+	BrickNo       int       `json:"brick_no"`
+	Name          string    `json:"name"`
+	IP            string    `json:"ip"`
+	MAC           string    `json:"mac"`
+	Version       string    `json:"version"`
+	Online        bool      `json:"online"`
+	LastHeartbeat time.Time `json:"last_heartbeat"`
+	LastPoll      time.Time `json:"last_poll"`
+	LastSeen      time.Time `json:"last_seen"`
+	Failures      int       `json:"failures"`
+	LastError     string    `json:"last_error,omitempty"`
+	Devices       int       `json:"devices"`
+}
+
+// stateRequest is the body for /state
+type stateRequest struct {
+	State  *bool `json:"state"`
+	Toggle bool  `json:"toggle"`
+}
+
+// levelRequest is the body for /level
+type levelRequest struct {
+	Level *float64 `json:"level"`
+}
+
+// ServeHTTP routes the request
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	switch {
+	case len(parts) == 1 && parts[0] == "bricks":
+		h.only(w, r, http.MethodGet, h.bricks)
+	case len(parts) == 3 && parts[0] == "bricks" && parts[2] == "config":
+		h.only(w, r, http.MethodGet, func(w http.ResponseWriter, r *http.Request) { h.brickConfig(w, parts[1]) })
+	case len(parts) == 1 && parts[0] == "devices":
+		h.only(w, r, http.MethodGet, h.devices)
+	case len(parts) == 1 && parts[0] == "events":
+		h.only(w, r, http.MethodGet, h.events)
+	case len(parts) == 1 && parts[0] == "metrics":
+		h.only(w, r, http.MethodGet, h.metrics)
+	case len(parts) == 2 && parts[0] == "devices":
+		h.only(w, r, http.MethodGet, func(w http.ResponseWriter, r *http.Request) { h.device(w, parts[1]) })
+	case len(parts) == 3 && parts[0] == "devices":
+		h.only(w, r, http.MethodPost, func(w http.ResponseWriter, r *http.Request) { h.command(w, r, parts[1], parts[2]) })
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("no such endpoint %s", r.URL.Path))
+	}
+}
+
+// only lets through the one method
+func (h *Handler) only(w http.ResponseWriter, r *http.Request, method string, serve http.HandlerFunc) {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s wants %s", r.URL.Path, method))
+		return
+	}
+	serve(w, r)
+}
+
+// bricks lists the bricks
+func (h *Handler) bricks(w http.ResponseWriter, r *http.Request) {
+
+	bricks := []Brick{}
+	for _, brickID := range h.Client.Bricks() {
+		_wbc, err := h.Client.BrickConfig(brickID)
+		if err != nil {
+			continue // Decommissioned since we asked
+		}
+		b := Brick{
+			BrickNo: brickID,
+			Name:    _wbc.Name,
+			IP:      _wbc.IP.IPString,
+			MAC:     _wbc.IP.MACString,
+			Version: _wbc.Version,
+			Devices: len(h.Client.Devices.ByBrick(brickID)),
+		}
+		if health, err := h.Client.Health(brickID); err == nil {
+			b.Online = health.Online
+			b.LastHeartbeat = health.LastHeartbeat
+			b.LastPoll = health.LastPoll
+			b.LastSeen = health.LastSeen
+			b.Failures = health.Failures
+			if health.LastError != nil {
+				b.LastError = health.LastError.Error()
+			}
+		}
+		bricks = append(bricks, b)
+	}
+
+	writeJSON(w, http.StatusOK, bricks)
+}
+
+// brickConfig is a brick's config
+func (h *Handler) brickConfig(w http.ResponseWriter, n string) {
+
+	brickID, err := strconv.Atoi(n)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("%w: bad brick %q", errBadRequest, n))
+		return
+	}
+	_wbc, err := h.Client.BrickConfig(brickID)
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, _wbc)
+}
+
+// devices lists the devices, narrowed down by ?brick= and ?type= (name or number)
+func (h *Handler) devices(w http.ResponseWriter, r *http.Request) {
+
+	devs := h.Client.Devices.All()
+	if devs == nil {
+		devs = []webbrick.Device{} // [] rather than null
+	}
+
+	if b := r.URL.Query().Get("brick"); b != "" {
+		brickID, err := strconv.Atoi(b)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("%w: bad brick %q", errBadRequest, b))
+			return
+		}
+		devs = keep(devs, func(d webbrick.Device) bool { return d.BrickID == brickID })
+	}
+	if t := r.URL.Query().Get("type"); t != "" {
+		devType, err := webbrick.ParseDeviceType(t)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("%w: %v", errBadRequest, err))
+			return
+		}
+		devs = keep(devs, func(d webbrick.Device) bool { return d.Type == devType })
+	}
+
+	writeJSON(w, http.StatusOK, devs)
+}
+
+// device is one device
+func (h *Handler) device(w http.ResponseWriter, devID string) {
+
+	dev, err := h.Client.Devices.Get(devID)
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, dev)
+}
+
+// command does what's asked of the device and hands it back as it is now
+func (h *Handler) command(w http.ResponseWriter, r *http.Request, devID, action string) {
+
+	dev, err := h.Client.Devices.Get(devID)
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	switch action {
+	case "state":
+		var req stateRequest
+		if err = readJSON(w, r, &req); err != nil {
+			break
+		}
+		switch {
+		case dev.Type != webbrick.LIGHT && dev.Type != webbrick.STATE:
+			err = fmt.Errorf("%w: %s is a %s, only lights and outputs have a state to set", errBadRequest, devID, webbrick.DeviceTypeName(dev.Type))
+		case req.Toggle:
+			_, err = h.Client.ToggleState(devID)
+		case req.State != nil:
+			_, err = h.Client.SetState(devID, *req.State)
+		default:
+			err = fmt.Errorf("%w: want {\"state\": true|false} or {\"toggle\": true}", errBadRequest)
+		}
+
+	case "level":
+		var req levelRequest
+		if err = readJSON(w, r, &req); err != nil {
+			break
+		}
+		switch {
+		case req.Level == nil:
+			err = fmt.Errorf("%w: want {\"level\": 0-100}", errBadRequest)
+		case *req.Level < 0 || *req.Level > 100:
+			err = fmt.Errorf("%w: level %v out of range 0-100", errBadRequest, *req.Level)
+		case dev.Type != webbrick.LIGHT:
+			err = fmt.Errorf("%w: %s is a %s, only lights take a level", errBadRequest, devID, webbrick.DeviceTypeName(dev.Type))
+		default:
+			_, err = h.Client.SetLightLevel(devID, *req.Level/100)
+		}
+
+	case "push":
+		if dev.Type != webbrick.BUTTON && dev.Type != webbrick.PIR {
+			err = fmt.Errorf("%w: %s is a %s, only buttons and PIRs can be pushed", errBadRequest, devID, webbrick.DeviceTypeName(dev.Type))
+			break
+		}
+		_, err = h.Client.PushButton(devID)
+
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("no such command %q, want state, level or push", action))
+		return
+	}
+
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	h.device(w, devID)
+}
+
+// keep is the devices that match
+func keep(devs []webbrick.Device, match func(d webbrick.Device) bool) []webbrick.Device {
+	kept := []webbrick.Device{}
+	for _, d := range devs {
+		if match(d) {
+			kept = append(kept, d)
+		}
+	}
+	return kept
+}
+
+// readJSON decodes a small JSON body. Anything bigger gets the connection closed after we answer
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBody)).Decode(v); err != nil {
+		return fmt.Errorf("%w: %v", errBadRequest, err)
+	}
+	return nil
+}
+
+// statusOf picks the HTTP status for an error
+func statusOf(err error) int {
+	switch {
+	case errors.Is(err, webbrick.ErrUnknownDevice), errors.Is(err, webbrick.ErrUnknownBrick):
+		return http.StatusNotFound
+	case errors.Is(err, errBadRequest):
+		return http.StatusBadRequest
+	}
+	return http.StatusBadGateway // The brick didn't do it
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
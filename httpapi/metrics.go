@@ -0,0 +1,188 @@
+package httpapi
+
+import (
+	"bufio"                           // For buffering the output
+	"fmt"                             // For the exposition format
+	"github.com/paulcull/go-webbrick" // For controlling webbrick stuff
+	"io"                              // For writing out
+	"net/http"                        // For serving
+	"sort"                            // For stable output
+	"strconv"                         // For labels and values
+	"strings"                         // For escaping labels
+)
+
+////////////////////
+// Prometheus metrics
+////////////////////
+// GET /metrics is the Prometheus text exposition format, for graphing heating zones and
+// alerting on dead bricks. Devices are labelled by brick, channel and name:
+//   webbrick_temperature_celsius     gauge, CT sensors
+//   webbrick_light_level             gauge, AO channels, as the brick reports them
+//   webbrick_output_state{type}      gauge, 1 on 0 off, digital outputs and lights
+//   webbrick_triggers_total{type}    counter, PIRs and buttons going off
+// Bricks by brick and name:
+//   webbrick_brick_up, webbrick_brick_last_seen_seconds, webbrick_polls_total{result}
+// And the library itself:
+//   webbrick_udp_packets_total{type}, webbrick_udp_bad_packets_total, webbrick_udp_unknown_packets_total,
+//   webbrick_events_dropped_total, webbrick_command_duration_seconds, webbrick_command_errors_total
+////////////////////
+
+// metrics writes out the metrics
+func (h *Handler) metrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	WriteMetrics(w, h.Client)
+}
+
+// metric is one family being written out
+type metric struct {
+	w *bufio.Writer
+}
+
+// family writes the HELP and TYPE lines
+func (m metric) family(name, kind, help string) {
+	fmt.Fprintf(m.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample writes a value, labels are name, value pairs
+func (m metric) sample(name string, value float64, labels ...string) {
+	m.w.WriteString(name)
+	if len(labels) > 0 {
+		m.w.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				m.w.WriteByte(',')
+			}
+			fmt.Fprintf(m.w, "%s=\"%s\"", labels[i], labelEscaper.Replace(labels[i+1]))
+		}
+		m.w.WriteByte('}')
+	}
+	m.w.WriteByte(' ')
+	m.w.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	m.w.WriteByte('\n')
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// WriteMetrics writes the client's metrics in the Prometheus text format, for anyone
+// serving them on their own
+func WriteMetrics(out io.Writer, c *webbrick.Client) error {
+
+	m := metric{w: bufio.NewWriter(out)}
+	stats := c.Stats()
+	devs := c.Devices.All()
+
+	devLabels := func(d webbrick.Device) []string {
+		return []string{"brick", strconv.Itoa(d.BrickID), "channel", strconv.Itoa(d.Channel), "name", d.Name}
+	}
+	boolean := func(b bool) float64 {
+		if b {
+			return 1
+		}
+		return 0
+	}
+
+	////////////////////
+	// devices
+	m.family("webbrick_temperature_celsius", "gauge", "Temperature from a CT sensor.")
+	for _, d := range devs {
+		if d.Type == webbrick.TEMP {
+			m.sample("webbrick_temperature_celsius", d.Level, devLabels(d)...)
+		}
+	}
+
+	m.family("webbrick_light_level", "gauge", "Level of an analogue output, as the brick reports it.")
+	for _, d := range devs {
+		if d.Type == webbrick.LIGHT {
+			m.sample("webbrick_light_level", d.Level, devLabels(d)...)
+		}
+	}
+
+	m.family("webbrick_output_state", "gauge", "Whether an output or light is on, 1 for on.")
+	for _, d := range devs {
+		if d.Type == webbrick.STATE || d.Type == webbrick.LIGHT { // DO 0 and AO 0 are both channel 0, type tells them apart
+			labels := append(devLabels(d), "type", webbrick.DeviceTypeName(d.Type))
+			m.sample("webbrick_output_state", boolean(d.State), labels...)
+		}
+	}
+
+	m.family("webbrick_triggers_total", "counter", "PIR and button triggers.")
+	for _, d := range devs {
+		if d.Type == webbrick.PIR || d.Type == webbrick.BUTTON {
+			labels := append(devLabels(d), "type", webbrick.DeviceTypeName(d.Type))
+			m.sample("webbrick_triggers_total", float64(stats.Triggers[d.DevID]), labels...)
+		}
+	}
+
+	////////////////////
+	// bricks
+	health := c.AllHealth()
+	brickName := func(brickID int) string {
+		if _wbc, err := c.BrickConfig(brickID); err == nil {
+			return _wbc.Name
+		}
+		return ""
+	}
+
+	m.family("webbrick_brick_up", "gauge", "Whether the brick has been heard from recently, 1 for up.")
+	for _, bh := range health {
+		m.sample("webbrick_brick_up", boolean(bh.Online), "brick", strconv.Itoa(bh.BrickID), "name", brickName(bh.BrickID))
+	}
+
+	m.family("webbrick_brick_last_seen_seconds", "gauge", "When the brick was last heard from, as a Unix time.")
+	for _, bh := range health {
+		if !bh.LastSeen.IsZero() {
+			m.sample("webbrick_brick_last_seen_seconds", float64(bh.LastSeen.Unix()), "brick", strconv.Itoa(bh.BrickID), "name", brickName(bh.BrickID))
+		}
+	}
+
+	m.family("webbrick_polls_total", "counter", "Status fetches from the brick, by result.")
+	for _, brickID := range sortedBricks(stats.Polls) {
+		p := stats.Polls[brickID]
+		name := brickName(brickID)
+		m.sample("webbrick_polls_total", float64(p.Succeeded), "brick", strconv.Itoa(brickID), "name", name, "result", "success")
+		m.sample("webbrick_polls_total", float64(p.Failed), "brick", strconv.Itoa(brickID), "name", name, "result", "failure")
+	}
+
+	////////////////////
+	// the library
+	m.family("webbrick_udp_packets_total", "counter", "UDP packets received, by type.")
+	types := make([]string, 0, len(stats.Packets))
+	for t := range stats.Packets {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	for _, t := range types {
+		m.sample("webbrick_udp_packets_total", float64(stats.Packets[t]), "type", t)
+	}
+
+	m.family("webbrick_udp_bad_packets_total", "counter", "UDP packets that wouldn't decode.")
+	m.sample("webbrick_udp_bad_packets_total", float64(stats.BadPackets))
+
+	m.family("webbrick_udp_unknown_packets_total", "counter", "UDP packets of an unknown type.")
+	m.sample("webbrick_udp_unknown_packets_total", float64(stats.UnknownPackets))
+
+	m.family("webbrick_events_dropped_total", "counter", "Events dropped because a subscriber was full.")
+	m.sample("webbrick_events_dropped_total", float64(stats.DroppedEvents))
+
+	m.family("webbrick_command_duration_seconds", "histogram", "How long commands to the bricks took.")
+	for i, bound := range webbrick.CommandBuckets {
+		m.sample("webbrick_command_duration_seconds_bucket", float64(stats.Commands.Buckets[i]), "le", strconv.FormatFloat(bound, 'g', -1, 64))
+	}
+	m.sample("webbrick_command_duration_seconds_bucket", float64(stats.Commands.Count), "le", "+Inf")
+	m.sample("webbrick_command_duration_seconds_sum", stats.Commands.Seconds)
+	m.sample("webbrick_command_duration_seconds_count", float64(stats.Commands.Count))
+
+	m.family("webbrick_command_errors_total", "counter", "Commands the bricks didn't take.")
+	m.sample("webbrick_command_errors_total", float64(stats.Commands.Errors))
+
+	return m.w.Flush()
+}
+
+func sortedBricks(polls map[int]webbrick.PollStats) []int {
+	bricks := make([]int, 0, len(polls))
+	for brickID := range polls {
+		bricks = append(bricks, brickID)
+	}
+	sort.Ints(bricks)
+	return bricks
+}
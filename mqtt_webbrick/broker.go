@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"                           // For stopping the reconnects
+	"encoding/json"                     // For the queue file
+	"fmt"                               // For outputting messages
+	"github.com/yosssi/gmq/mqtt/client" //mqtt libraries
+	"io/ioutil"                         // For the queue file
+	"os"                                // For the queue file
+	"sync"                              // For guarding the connection and queue
+	"time"                              // For the backoff
+)
+
+////////////////////
+// Broker connection
+////////////////////
+// The connection to the broker comes and goes. While it's down
+// 1. anything we publish goes in a bounded queue, oldest dropped first, and
+//    written to queue.file if there is one so a restart doesn't lose it
+// 2. we keep trying to reconnect, backing off from reconnect.min_seconds to reconnect.max_seconds
+// Once back we say we're online, subscribe to the commands again and flush the queue in order.
+// Commands are run one at a time, in the order they came in, by a single worker.
+////////////////////
+
+// commandBacklog is how many commands can wait for the worker before new ones are dropped
+const commandBacklog = 100
+
+// queuedMessage is a publish waiting for the broker
+type queuedMessage struct {
+	Topic   string `json:"topic"`
+	Message string `json:"message"`
+	Retain  bool   `json:"retain"`
+}
+
+// inbound is a command waiting for the worker
+type inbound struct {
+	topic   string
+	message string
+}
+
+// mqttConn is the bridge's connection to the broker, whatever state it's in
+type mqttConn struct {
+	opts    *client.ConnectOptions
+	handler func(topicName, message string) // For the commands
+
+	mu        sync.Mutex // Held while publishing, so the queue and new messages stay in order
+	cli       *client.Client
+	connected bool
+	queue     []queuedMessage
+	dropped   int // Queued messages dropped because the queue was full
+
+	down     chan struct{} // The connection has gone
+	commands chan inbound  // Waiting for the worker
+}
+
+func newMQTTConn(opts *client.ConnectOptions, handler func(topicName, message string)) *mqttConn {
+
+	m := &mqttConn{
+		opts:     opts,
+		handler:  handler,
+		down:     make(chan struct{}, 1),
+		commands: make(chan inbound, commandBacklog),
+	}
+	m.loadQueue()
+	return m
+}
+
+// run keeps us connected, and the commands running, until ctx is done
+func (m *mqttConn) run(ctx context.Context) {
+
+	go m.work(ctx)
+
+	for {
+		m.connect(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.down:
+		}
+
+		m.mu.Lock()
+		old := m.cli
+		m.cli = nil
+		m.connected = false
+		m.mu.Unlock()
+
+		fmt.Println(" !!!!!!!!!!!!!!!!! Lost the mqtt broker, reconnecting...")
+		if old != nil {
+			go old.Terminate() // it's gone anyway, don't wait on it
+		}
+	}
+}
+
+// connect tries until it gets through to the broker, or ctx is done
+func (m *mqttConn) connect(ctx context.Context) {
+
+	wait := bridge.Reconnect.min()
+	for {
+		fmt.Println("Setting up mqtt client...Connecting...", m.opts.Address)
+		err := m.dial()
+		if err == nil {
+			fmt.Println("Setting up mqtt client...Connecting...done")
+			return
+		}
+		fmt.Println(" !!!!!!!!!!!!!!!!! Couldn't connect to mqtt broker, trying again in", wait, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		if wait *= 2; wait > bridge.Reconnect.max() {
+			wait = bridge.Reconnect.max()
+		}
+	}
+}
+
+// dial makes a new client, connects, subscribes and flushes the queue
+func (m *mqttConn) dial() error {
+
+	var cli *client.Client
+	cli = client.New(&client.Options{
+		ErrorHandler: func(err error) {
+			fmt.Println(err)
+			go m.lost(cli) // not while a publish might hold the lock
+		},
+	})
+
+	if err := cli.Connect(m.opts); err != nil {
+		cli.Terminate()
+		return err
+	}
+
+	err := cli.Subscribe(&client.SubscribeOptions{
+		SubReqs: []*client.SubReq{
+			&client.SubReq{
+				TopicFilter: []byte(commandPrefix() + "#"),
+				QoS:         bridge.qos(),
+				Handler: func(topicName, message []byte) {
+					m.received(string(topicName), string(message))
+				},
+			},
+		},
+	})
+	if err != nil {
+		cli.Disconnect()
+		cli.Terminate()
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.cli = cli
+	// the will went out when we dropped, so say we're back before anything else
+	if err := m.send(queuedMessage{Topic: bridgeAvailability(), Message: online, Retain: true}); err != nil {
+		m.cli = nil
+		cli.Terminate()
+		return err
+	}
+
+	if len(m.queue) > 0 {
+		fmt.Println("Setting up mqtt client...Flushing", len(m.queue), "queued messages...")
+	}
+	for len(m.queue) > 0 {
+		if err := m.send(m.queue[0]); err != nil {
+			m.saveQueue()
+			m.cli = nil
+			cli.Terminate()
+			return err
+		}
+		m.queue = m.queue[1:]
+	}
+	m.saveQueue()
+
+	m.connected = true
+	return nil
+}
+
+// received hands a command to the worker. Commands can take a while, so this doesn't
+// wait: if the worker is that far behind the command is dropped rather than holding up mqtt
+func (m *mqttConn) received(topicName, message string) {
+	select {
+	case m.commands <- inbound{topic: topicName, message: message}:
+	default:
+		fmt.Println(" !!!!!!!!!!!!!!!!! Too many commands waiting, dropped", topicName, message)
+	}
+}
+
+// work runs the commands one at a time, so e.g. an "on" and an "off" sent together
+// happen in the order they were sent
+func (m *mqttConn) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case cmd := <-m.commands:
+			m.handler(cmd.topic, cmd.message)
+		}
+	}
+}
+
+// lost is told by a client's error handler that its connection has gone
+func (m *mqttConn) lost(cli *client.Client) {
+
+	m.mu.Lock()
+	current := cli == m.cli && m.connected
+	m.mu.Unlock()
+
+	if current {
+		select {
+		case m.down <- struct{}{}:
+		default: // already on its way
+		}
+	}
+}
+
+// publish sends the message, or queues it if we can't right now. queue false drops it instead,
+// for things that are no use late like the heartbeat. sent is false if it didn't go straight out
+func (m *mqttConn) publish(msg queuedMessage, queue bool) (sent bool, err error) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.connected {
+		if err = m.send(msg); err == nil {
+			return true, nil
+		}
+		m.connected = false
+		select {
+		case m.down <- struct{}{}:
+		default:
+		}
+	}
+
+	if !queue {
+		return false, err
+	}
+	m.enqueue(msg)
+	fmt.Println("Setting up mqtt client publish...Queued...", msg.Topic, len(m.queue), "waiting")
+	return false, nil
+}
+
+// send is a straight publish, the lock must be held
+func (m *mqttConn) send(msg queuedMessage) error {
+	return m.cli.Publish(&client.PublishOptions{
+		QoS:       bridge.qos(),
+		Retain:    msg.Retain,
+		TopicName: []byte(msg.Topic),
+		Message:   []byte(msg.Message),
+	})
+}
+
+// enqueue adds to the queue, dropping the oldest if it's full. The lock must be held
+func (m *mqttConn) enqueue(msg queuedMessage) {
+
+	m.queue = append(m.queue, msg)
+	if over := len(m.queue) - bridge.Queue.Size; over > 0 {
+		m.queue = m.queue[over:]
+		m.dropped += over
+		fmt.Println(" !!!!!!!!!!!!!!!!! Publish queue full, dropped", m.dropped, "so far")
+	}
+	m.saveQueue()
+}
+
+// close says we're going and disconnects, a clean disconnect doesn't fire the will
+func (m *mqttConn) close() {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cli == nil {
+		return
+	}
+	if m.connected {
+		m.send(queuedMessage{Topic: bridgeAvailability(), Message: offline, Retain: true})
+
+		fmt.Println("******** Setting up mqtt client...Unsubscribing...")
+		if err := m.cli.Unsubscribe(&client.UnsubscribeOptions{
+			TopicFilters: [][]byte{
+				[]byte(commandPrefix() + "#"),
+			},
+		}); err != nil {
+			fmt.Println(err)
+		}
+
+		fmt.Println("******** Setting up mqtt client...Dis-connecting...")
+		if err := m.cli.Disconnect(); err != nil {
+			fmt.Println(err)
+		}
+	}
+
+	fmt.Println("******** Setting up mqtt client...Terminating...")
+	m.cli.Terminate()
+	m.cli = nil
+	m.connected = false
+}
+
+////////////////////
+// Queue file
+////////////////////
+
+// loadQueue picks up whatever was left queued last time
+func (m *mqttConn) loadQueue() {
+
+	if bridge.Queue.File == "" {
+		return
+	}
+	data, err := ioutil.ReadFile(bridge.Queue.File)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &m.queue)
+	}
+	if err != nil {
+		fmt.Println(" !!!!!!!!!!!!!!!!! Couldn't read publish queue", bridge.Queue.File, err)
+		return
+	}
+	if over := len(m.queue) - bridge.Queue.Size; over > 0 {
+		m.queue = m.queue[over:]
+	}
+	fmt.Println("Loaded", len(m.queue), "queued messages from", bridge.Queue.File)
+}
+
+// saveQueue writes out the queue, or clears the file once it's empty. The lock must be held
+func (m *mqttConn) saveQueue() {
+
+	if bridge.Queue.File == "" {
+		return
+	}
+	if len(m.queue) == 0 {
+		if err := os.Remove(bridge.Queue.File); err != nil && !os.IsNotExist(err) {
+			fmt.Println(" !!!!!!!!!!!!!!!!! Couldn't clear publish queue", bridge.Queue.File, err)
+		}
+		return
+	}
+
+	data, _ := json.Marshal(m.queue)
+	tmp := bridge.Queue.File + ".tmp"
+	err := ioutil.WriteFile(tmp, data, 0600)
+	if err == nil {
+		err = os.Rename(tmp, bridge.Queue.File) // so a crash never leaves half a file
+	}
+	if err != nil {
+		fmt.Println(" !!!!!!!!!!!!!!!!! Couldn't save publish queue", bridge.Queue.File, err)
+	}
+}
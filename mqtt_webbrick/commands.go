@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"                   // For the results
+	"errors"                          // For crafting our own errors
+	"fmt"                             // For outputting messages
+	"github.com/paulcull/go-webbrick" // For controlling  stuff
+	"strconv"                         // For topic and level parsing
+	"strings"                         // For topic splitting
+)
+
+////////////////////
+// Inbound commands
+////////////////////
+// Commands come in on
+//   webbrick/to/<brick>/<type>/<channel>/set
+// (or under whatever topic_prefix is set to) where <type> is the type number, as on the webbrick/from topics, or its name
+// (light, pir, button, temp, state). The payload is one of
+//   on | off | toggle (lights and outputs) | 0-100 (lights) | push (buttons and PIRs) | refresh
+// Commands for the brick as a whole come in on
+//   webbrick/to/<brick>/set
+// with refresh, or decommission to forget the brick and remove it from Home Assistant.
+// How it went is published, as JSON, on
+//   webbrick/result/<brick>/<type>/<channel>
+////////////////////
+
+// commandPrefix is where commands come in, webbrick/to/ unless the prefix is changed
+func commandPrefix() string { return topic("to") + "/" }
+
+// resultPrefix is where we say how they went
+func resultPrefix() string { return topic("result") + "/" }
+
+var errBadTopic = errors.New("command topic should be webbrick/to/<brick>/<type>/<channel>/set or webbrick/to/<brick>/set")
+
+// commandResult is the ack, or error, for a command
+type commandResult struct {
+	Topic   string `json:"topic"`
+	Command string `json:"command"`
+	OK      bool   `json:"ok"`
+	Error   string `json:"error,omitempty"`
+}
+
+func actOnMessage(cli *mqttConn, topicName, message string) {
+
+	fmt.Println(" **************************** in actOnMessage ***", topicName, message)
+
+	command := strings.ToLower(strings.TrimSpace(message))
+	brick, devType, channel, err := parseCommandTopic(topicName)
+	if err == nil {
+		err = runCommand(brick, devType, channel, command)
+	}
+
+	result := commandResult{Topic: topicName, Command: command, OK: err == nil}
+	resultTopic := resultPrefix() + "error"
+	if err != nil {
+		fmt.Println(" !!!!!!!!!!!!!!!!! Command failed", topicName, err)
+		result.Error = err.Error()
+	}
+	if brick >= 0 {
+		resultTopic = resultPrefix() + strconv.Itoa(brick) + "/" + strconv.Itoa(devType) + "/" + strconv.Itoa(channel)
+	}
+
+	strResult, _ := json.Marshal(result)
+	if sent, err := publishMessage(cli, string(strResult), resultTopic); err != nil && sent == false {
+		fmt.Println(" !!!!!!!!!!!!!!!!! Error publishing command result", err)
+	}
+}
+
+// parseCommandTopic pulls the brick, type and channel out of a command topic. brick is -1
+// if the topic doesn't make sense. Brick level commands come back as the brick's HEARTBEAT device
+func parseCommandTopic(topicName string) (brick, devType, channel int, err error) {
+
+	parts := strings.Split(strings.TrimPrefix(topicName, commandPrefix()), "/")
+	if !strings.HasPrefix(topicName, commandPrefix()) || (len(parts) != 4 && len(parts) != 2) || parts[len(parts)-1] != "set" {
+		return -1, 0, 0, errBadTopic
+	}
+
+	if brick, err = strconv.Atoi(parts[0]); err != nil {
+		return -1, 0, 0, fmt.Errorf("%w: bad brick %q", errBadTopic, parts[0])
+	}
+	if len(parts) == 2 {
+		return brick, webbrick.HEARTBEAT, 0, nil
+	}
+	if devType, err = webbrick.ParseDeviceType(parts[1]); err != nil {
+		return -1, 0, 0, err
+	}
+	if channel, err = strconv.Atoi(parts[2]); err != nil {
+		return -1, 0, 0, fmt.Errorf("%w: bad channel %q", errBadTopic, parts[2])
+	}
+
+	return brick, devType, channel, nil
+}
+
+// runCommand does what the payload asks of the device
+func runCommand(brick, devType, channel int, command string) error {
+
+	if command == "refresh" {
+		return webbrick.RefreshBrick(brick)
+	}
+	if devType == webbrick.HEARTBEAT {
+		if command != "decommission" {
+			return fmt.Errorf("unknown brick command %q, want refresh or decommission", command)
+		}
+		_, err := webbrick.DecommissionBrick(brick)
+		return err
+	}
+
+	dev, err := webbrick.Devices.Find(brick, devType, channel)
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "on", "off", "toggle":
+		if dev.Type != webbrick.LIGHT && dev.Type != webbrick.STATE {
+			return fmt.Errorf("%s is a %s, only lights and outputs can be turned %s", dev.DevID, webbrick.DeviceTypeName(dev.Type), command)
+		}
+		if command == "toggle" {
+			_, err = webbrick.ToggleState(dev.DevID)
+		} else {
+			_, err = webbrick.SetState(dev.DevID, command == "on")
+		}
+	case "push":
+		if dev.Type != webbrick.BUTTON && dev.Type != webbrick.PIR {
+			return fmt.Errorf("%s is a %s, only buttons and PIRs can be pushed", dev.DevID, webbrick.DeviceTypeName(dev.Type))
+		}
+		_, err = webbrick.PushButton(dev.DevID)
+	default:
+		level, convErr := strconv.Atoi(command)
+		switch {
+		case convErr != nil:
+			return fmt.Errorf("unknown command %q, want on, off, toggle, 0-100, push or refresh", command)
+		case level < 0 || level > 100:
+			return fmt.Errorf("level %d out of range 0-100", level)
+		case dev.Type != webbrick.LIGHT:
+			return fmt.Errorf("%s is a %s, only lights take a level", dev.DevID, webbrick.DeviceTypeName(dev.Type))
+		}
+		_, err = webbrick.SetLightLevel(dev.DevID, float64(level)/100)
+	}
+
+	return err
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"crypto/tls"                        // For TLS to the broker
+	"crypto/x509"                       // For the broker's CA
+	"encoding/json"                     // For the config file
+	"errors"                            // For crafting our own errors
+	"flag"                              // For command line overrides
+	"fmt"                               // For outputting messages
+	"github.com/paulcull/go-webbrick"   // For controlling  stuff
+	"github.com/yosssi/gmq/mqtt/client" //mqtt libraries
+	"io/ioutil"                         // For reading files
+	"os"                                // For env overrides
+	"strconv"                           // For env overrides
+	"strings"                           // For tidying the prefix
+	"time"                              // For the heartbeat
+)
+
+////////////////////
+// Bridge config
+////////////////////
+// Loaded in order, each overriding the last:
+//   1. the defaults below
+//   2. a JSON config file, -config or $WEBBRICK_CONFIG (see etc/mqtt_webbrick.json)
+//   3. environment variables, $WEBBRICK_BROKER etc.
+//   4. command line flags
+////////////////////
+
+// bridgeConfig is everything the bridge can be told
+type bridgeConfig struct {
+	Broker           brokerConfig              `json:"broker"`
+	TopicPrefix      string                    `json:"topic_prefix"`      // Everything we publish goes under here, default "webbrick"
+	DiscoveryPrefix  string                    `json:"discovery_prefix"`  // Home Assistant's discovery prefix, default "homeassistant"
+	HomeAssistant    bool                      `json:"home_assistant"`    // Publish Home Assistant discovery
+	QoS              int                       `json:"qos"`               // For everything we publish and subscribe to, 0-2
+	RetainState      bool                      `json:"retain_state"`      // Retain the JSON state topics
+	HeartbeatSeconds int                       `json:"heartbeat_seconds"` // How often webbrick/bridge/heartbeat goes out
+	Reconnect        reconnectConfig           `json:"reconnect"`
+	Queue            queueConfig               `json:"queue"`
+	Webbrick         webbrickConfig            `json:"webbrick"`
+	Devices          map[string]deviceOverride `json:"devices"` // Keyed on device ID, e.g. "2::TD::0"
+}
+
+type brokerConfig struct {
+	Address  string `json:"address"` // host:port
+	ClientID string `json:"client_id"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	CAFile   string `json:"ca_file"`   // Turns on TLS, checking the broker against this CA
+	CertFile string `json:"cert_file"` // Client certificate, with KeyFile
+	KeyFile  string `json:"key_file"`
+}
+
+// reconnectConfig is how hard we try to get back to the broker
+type reconnectConfig struct {
+	MinSeconds int `json:"min_seconds"` // First wait, doubling each failed try
+	MaxSeconds int `json:"max_seconds"` // Longest wait
+}
+
+// queueConfig is for what we publish while the broker is away
+type queueConfig struct {
+	Size int    `json:"size"` // Most messages held, the oldest are dropped after that
+	File string `json:"file"` // Keep the queue here too, so it survives a restart
+}
+
+type webbrickConfig struct {
+	Name             string            `json:"name"`
+	UDPPort          string            `json:"udp_port"`
+	PollingMinutes   int               `json:"polling_minutes"`
+	PollingActive    bool              `json:"polling_active"`
+	Debug            bool              `json:"debug"`
+	UDPCommandBricks []int             `json:"udp_command_bricks"`
+	Gateway          *webbrick.Gateway `json:"gateway"`
+}
+
+// deviceOverride changes how a single device is handled
+type deviceOverride struct {
+	Name    string `json:"name"`    // Name to publish under, rather than the one on the brick
+	PIR     *bool  `json:"pir"`     // The trigger input is a PIR, not a button. Unset leaves the library's PIRS list alone
+	Exclude *bool  `json:"exclude"` // Ignore the device altogether. Unset leaves the library's EXCLUDE list alone
+}
+
+// bridge is the config we're running with
+var bridge = defaultBridgeConfig()
+
+func defaultBridgeConfig() bridgeConfig {
+	return bridgeConfig{
+		Broker: brokerConfig{
+			Address:  "localhost:1883",
+			ClientID: "webbrickBridge",
+		},
+		TopicPrefix:      "webbrick",
+		DiscoveryPrefix:  "homeassistant",
+		HomeAssistant:    true,
+		QoS:              1,
+		RetainState:      true,
+		HeartbeatSeconds: 59,
+		Reconnect:        reconnectConfig{MinSeconds: 1, MaxSeconds: 60},
+		Queue:            queueConfig{Size: 1000},
+		Webbrick: webbrickConfig{
+			Name:           "PKHome",
+			UDPPort:        webbrick.UDPPort,
+			PollingMinutes: 5,
+		},
+	}
+}
+
+// loadConfig works out the config from the file, environment and flags
+func loadConfig(args []string) (bridgeConfig, error) {
+
+	cfg := defaultBridgeConfig()
+
+	flags := flag.NewFlagSet("mqtt_webbrick", flag.ContinueOnError)
+	path := flags.String("config", os.Getenv("WEBBRICK_CONFIG"), "JSON config file")
+	broker := flags.String("broker", "", "MQTT broker host:port")
+	clientID := flags.String("client-id", "", "MQTT client ID")
+	username := flags.String("username", "", "MQTT username")
+	password := flags.String("password", "", "MQTT password")
+	prefix := flags.String("prefix", "", "topic prefix")
+	udpPort := flags.String("udp-port", "", "UDP port the bricks send to")
+	debug := flags.Bool("debug", false, "dump out packets and devices")
+	queueFile := flags.String("queue-file", "", "keep the publish queue in this file")
+	if err := flags.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	// 2. the file
+	if *path != "" {
+		data, err := ioutil.ReadFile(*path)
+		if err != nil {
+			return cfg, err
+		}
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return cfg, fmt.Errorf("reading %s: %w", *path, err)
+		}
+		fmt.Println("Loaded config from", *path)
+	}
+
+	// 3. the environment
+	env := func(key string, into *string) {
+		if v, ok := os.LookupEnv(key); ok {
+			*into = v
+		}
+	}
+	env("WEBBRICK_BROKER", &cfg.Broker.Address)
+	env("WEBBRICK_CLIENT_ID", &cfg.Broker.ClientID)
+	env("WEBBRICK_USERNAME", &cfg.Broker.Username)
+	env("WEBBRICK_PASSWORD", &cfg.Broker.Password)
+	env("WEBBRICK_CA_FILE", &cfg.Broker.CAFile)
+	env("WEBBRICK_CERT_FILE", &cfg.Broker.CertFile)
+	env("WEBBRICK_KEY_FILE", &cfg.Broker.KeyFile)
+	env("WEBBRICK_TOPIC_PREFIX", &cfg.TopicPrefix)
+	env("WEBBRICK_UDP_PORT", &cfg.Webbrick.UDPPort)
+	env("WEBBRICK_QUEUE_FILE", &cfg.Queue.File)
+	if v, ok := os.LookupEnv("WEBBRICK_DEBUG"); ok {
+		cfg.Webbrick.Debug, _ = strconv.ParseBool(v)
+	}
+
+	// 4. the flags, only the ones given
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "broker":
+			cfg.Broker.Address = *broker
+		case "client-id":
+			cfg.Broker.ClientID = *clientID
+		case "username":
+			cfg.Broker.Username = *username
+		case "password":
+			cfg.Broker.Password = *password
+		case "prefix":
+			cfg.TopicPrefix = *prefix
+		case "udp-port":
+			cfg.Webbrick.UDPPort = *udpPort
+		case "debug":
+			cfg.Webbrick.Debug = *debug
+		case "queue-file":
+			cfg.Queue.File = *queueFile
+		}
+	})
+
+	cfg.TopicPrefix = strings.Trim(cfg.TopicPrefix, "/")
+	cfg.DiscoveryPrefix = strings.Trim(cfg.DiscoveryPrefix, "/")
+	switch {
+	case cfg.TopicPrefix == "":
+		return cfg, errors.New("topic_prefix can't be empty")
+	case cfg.QoS < 0 || cfg.QoS > 2:
+		return cfg, fmt.Errorf("qos %d should be 0, 1 or 2", cfg.QoS)
+	case cfg.HeartbeatSeconds <= 0:
+		return cfg, fmt.Errorf("heartbeat_seconds %d should be more than 0", cfg.HeartbeatSeconds)
+	case cfg.Reconnect.MinSeconds <= 0 || cfg.Reconnect.MaxSeconds < cfg.Reconnect.MinSeconds:
+		return cfg, fmt.Errorf("reconnect should be 0 < min_seconds (%d) <= max_seconds (%d)", cfg.Reconnect.MinSeconds, cfg.Reconnect.MaxSeconds)
+	case cfg.Queue.Size <= 0:
+		return cfg, fmt.Errorf("queue size %d should be more than 0", cfg.Queue.Size)
+	}
+
+	return cfg, nil
+}
+
+// driverConfig is the webbrick library's config
+func (cfg bridgeConfig) driverConfig() *webbrick.WebbrickDriverConfig {
+
+	wbdc := &webbrick.WebbrickDriverConfig{
+		Name:             cfg.Webbrick.Name,
+		Initialised:      false,
+		NumberOfDevices:  0,
+		PollingMinutes:   cfg.Webbrick.PollingMinutes,
+		PollingActive:    cfg.Webbrick.PollingActive,
+		UDPPort:          cfg.Webbrick.UDPPort,
+		Debug:            cfg.Webbrick.Debug,
+		UDPCommandBricks: cfg.Webbrick.UDPCommandBricks,
+		Gateway:          cfg.Webbrick.Gateway,
+	}
+
+	// Device overrides go on top of the library's own lists
+	if len(cfg.Devices) > 0 {
+		wbdc.Pirs = make(map[string]bool)
+		for devID, pir := range webbrick.PIRS {
+			wbdc.Pirs[devID] = pir
+		}
+		wbdc.Exclude = make(map[string]bool)
+		for devID, exclude := range webbrick.EXCLUDE {
+			wbdc.Exclude[devID] = exclude
+		}
+		for devID, o := range cfg.Devices {
+			if o.PIR != nil {
+				wbdc.Pirs[devID] = *o.PIR
+			}
+			if o.Exclude != nil {
+				wbdc.Exclude[devID] = *o.Exclude
+			}
+		}
+	}
+
+	return wbdc
+}
+
+// connectOptions are how we connect to the broker
+func (cfg bridgeConfig) connectOptions() (*client.ConnectOptions, error) {
+
+	opts := &client.ConnectOptions{
+		Network:  "tcp",
+		Address:  cfg.Broker.Address,
+		ClientID: []byte(cfg.Broker.ClientID),
+		// if we drop off the broker says so for us
+		WillTopic:   []byte(bridgeAvailability()),
+		WillMessage: []byte(offline),
+		WillQoS:     cfg.qos(),
+		WillRetain:  true,
+	}
+	if cfg.Broker.Username != "" {
+		opts.UserName = []byte(cfg.Broker.Username)
+		opts.Password = []byte(cfg.Broker.Password)
+	}
+
+	if cfg.Broker.CAFile != "" || cfg.Broker.CertFile != "" {
+		tlsConfig := &tls.Config{}
+		if cfg.Broker.CAFile != "" {
+			ca, err := ioutil.ReadFile(cfg.Broker.CAFile)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+				return nil, fmt.Errorf("no certificates in %s", cfg.Broker.CAFile)
+			}
+		}
+		if cfg.Broker.CertFile != "" {
+			cert, err := tls.LoadX509KeyPair(cfg.Broker.CertFile, cfg.Broker.KeyFile)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		opts.TLSConfig = tlsConfig
+	}
+
+	return opts, nil
+}
+
+// qos is the configured QoS as mqtt wants it
+func (cfg bridgeConfig) qos() byte {
+	return byte(cfg.QoS)
+}
+
+// heartbeat is how often the bridge says it's alive
+func (cfg bridgeConfig) heartbeat() time.Duration {
+	return time.Duration(cfg.HeartbeatSeconds) * time.Second
+}
+
+func (r reconnectConfig) min() time.Duration {
+	return time.Duration(r.MinSeconds) * time.Second
+}
+
+func (r reconnectConfig) max() time.Duration {
+	return time.Duration(r.MaxSeconds) * time.Second
+}
+
+// displayName is the name a device is published under, the override if there is one
+func displayName(dev webbrick.Device) string {
+	if o, ok := bridge.Devices[dev.DevID]; ok && o.Name != "" {
+		return o.Name
+	}
+	return dev.Name
+}
+
+// topic builds a topic under the prefix
+func topic(parts ...string) string {
+	return bridge.TopicPrefix + "/" + strings.Join(parts, "/")
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"                   // For the discovery payloads
+	"fmt"                             // For outputting messages
+	"github.com/paulcull/go-webbrick" // For controlling  stuff
+	"strconv"                         // For topic construction
+	"sync"                            // For guarding what we've announced
+)
+
+////////////////////
+// Home Assistant discovery
+////////////////////
+// Each device gets a retained config on
+//   homeassistant/<component>/<id>/config
+// (discovery_prefix in the config), unless home_assistant is turned off.
+// LIGHT -> light, PIR -> binary_sensor (motion), TEMP -> sensor (°C),
+// STATE -> switch and BUTTON -> device_automation (a trigger). Button presses are
+// published as "press" on
+//   webbrick/press/<brick>/<type>/<channel>
+// only when a brick sends the trigger, so polls and our own commands don't look like presses.
+// Entities are grouped into one Home Assistant device per brick, and their
+// configs are cleared when the brick is decommissioned.
+////////////////////
+
+// announced is the last config we published for each device, so we only re-publish on a change
+var announced = struct {
+	sync.Mutex
+	configs map[string]string
+}{configs: make(map[string]string)}
+
+// haDevice is the Home Assistant device, one per brick
+type haDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model"`
+	SWVersion    string   `json:"sw_version,omitempty"`
+}
+
+// announceDevice publishes, or re-publishes if it has changed, a device's discovery config
+func announceDevice(cli *mqttConn, dev webbrick.Device) {
+
+	if !bridge.HomeAssistant {
+		return
+	}
+
+	topicName, config := discoveryConfig(dev)
+	if topicName == "" {
+		return // Not something Home Assistant needs to know about
+	}
+
+	strConfig, _ := json.Marshal(config)
+
+	announced.Lock()
+	same := announced.configs[topicName] == string(strConfig)
+	announced.configs[topicName] = string(strConfig)
+	announced.Unlock()
+
+	if same {
+		return
+	}
+
+	if sent, err := publishRetained(cli, string(strConfig), topicName); err != nil && sent == false {
+		fmt.Println(" !!!!!!!!!!!!!!!!! Error publishing discovery for", dev.DevID, err)
+	}
+}
+
+// removeDevice clears a device's discovery config, which takes it out of Home Assistant
+func removeDevice(cli *mqttConn, dev webbrick.Device) {
+
+	if !bridge.HomeAssistant {
+		return
+	}
+
+	topicName, _ := discoveryConfig(dev)
+	if topicName == "" {
+		return
+	}
+
+	announced.Lock()
+	delete(announced.configs, topicName)
+	announced.Unlock()
+
+	if sent, err := publishRetained(cli, "", topicName); err != nil && sent == false {
+		fmt.Println(" !!!!!!!!!!!!!!!!! Error removing discovery for", dev.DevID, err)
+	}
+}
+
+// discoveryConfig is the topic and config for a device, or no topic for devices Home Assistant doesn't get
+func discoveryConfig(dev webbrick.Device) (string, map[string]interface{}) {
+
+	id := bridge.TopicPrefix + "_" + strconv.Itoa(dev.BrickID) + "_" + webbrick.DeviceTypeName(dev.Type) + "_" + strconv.Itoa(dev.Channel)
+	name := displayName(dev)
+	if name == "" {
+		name = webbrick.DeviceTypeName(dev.Type) + " " + strconv.Itoa(dev.Channel)
+	}
+
+	config := map[string]interface{}{
+		"name":      name,
+		"unique_id": id,
+		"device":    brickDevice(dev.BrickID),
+		"availability": []map[string]string{
+			{"topic": bridgeAvailability()},
+			{"topic": brickAvailabilityTopic(dev.BrickID)},
+		},
+		"availability_mode": "all",
+	}
+
+	var component string
+	switch dev.Type {
+	case webbrick.LIGHT:
+		component = "light"
+		config["command_topic"] = setTopic(dev)
+		config["payload_on"] = "on"
+		config["payload_off"] = "off"
+		config["state_topic"] = stateTopic(dev)
+		config["state_value_template"] = "{{ 'on' if value_json.state else 'off' }}"
+		config["brightness_command_topic"] = setTopic(dev)
+		config["brightness_state_topic"] = stateTopic(dev)
+		config["brightness_value_template"] = "{{ value_json.level | round(0) }}" // Device.Level is 0-100, however it was set
+		config["brightness_scale"] = 100
+		config["on_command_type"] = "brightness"
+
+	case webbrick.PIR:
+		component = "binary_sensor"
+		config["device_class"] = "motion"
+		config["state_topic"] = stateTopic(dev)
+		config["value_template"] = "{{ 'ON' if value_json.state else 'OFF' }}"
+		config["off_delay"] = 60 // Bricks only tell us when a PIR fires
+
+	case webbrick.TEMP:
+		component = "sensor"
+		config["device_class"] = "temperature"
+		config["unit_of_measurement"] = "°C"
+		config["state_topic"] = stateTopic(dev)
+		config["value_template"] = "{{ value_json.level }}"
+
+	case webbrick.STATE:
+		component = "switch"
+		config["command_topic"] = setTopic(dev)
+		config["payload_on"] = "on"
+		config["payload_off"] = "off"
+		config["state_topic"] = stateTopic(dev)
+		config["value_template"] = "{{ 'on' if value_json.state else 'off' }}"
+		config["state_on"] = "on"
+		config["state_off"] = "off"
+
+	case webbrick.BUTTON:
+		component = "device_automation"
+		config = map[string]interface{}{
+			"automation_type": "trigger",
+			"topic":           pressTopic(dev),
+			"payload":         pressPayload,
+			"type":            "button_short_press",
+			"subtype":         name,
+			"device":          brickDevice(dev.BrickID),
+		}
+
+	default:
+		return "", nil
+	}
+
+	return bridge.DiscoveryPrefix + "/" + component + "/" + id + "/config", config
+}
+
+// brickDevice is the Home Assistant device for a brick, named from its config if we have it
+func brickDevice(brickID int) haDevice {
+
+	d := haDevice{
+		Identifiers:  []string{"webbrick_" + strconv.Itoa(brickID)},
+		Name:         "WebBrick " + strconv.Itoa(brickID),
+		Manufacturer: "WebBrick Systems",
+		Model:        "WebBrick",
+	}
+	if cfg, err := webbrick.BrickConfig(brickID); err == nil {
+		if cfg.Name != "" {
+			d.Name = cfg.Name
+		}
+		d.SWVersion = cfg.Version
+	}
+	return d
+}
+
+// fromTopic is where a device's bare value is published
+func fromTopic(dev webbrick.Device) string {
+	return topic("from", strconv.Itoa(dev.BrickID), strconv.Itoa(dev.Type), strconv.Itoa(dev.Channel))
+}
+
+// pressPayload is what a button press is published as
+const pressPayload = "press"
+
+// pressTopic is where a button's presses are published
+func pressTopic(dev webbrick.Device) string {
+	return topic("press", strconv.Itoa(dev.BrickID), strconv.Itoa(dev.Type), strconv.Itoa(dev.Channel))
+}
+
+// publishPress passes on a button press. Only a trigger packet from the brick is a press,
+// and a late one is no use to anyone, so it isn't queued while the broker is away
+func publishPress(cli *mqttConn, msg webbrick.EventStruct) {
+
+	if msg.DeviceInfo.Type != webbrick.BUTTON || msg.Source != webbrick.SourceUDP {
+		return
+	}
+
+	if sent, err := cli.publish(queuedMessage{Topic: pressTopic(msg.DeviceInfo), Message: pressPayload}, false); err != nil && sent == false {
+		fmt.Println(" !!!!!!!!!!!!!!!!! Error publishing press for", msg.DeviceInfo.DevID, err)
+	}
+}
+
+// setTopic is where a device takes commands
+func setTopic(dev webbrick.Device) string {
+	return commandPrefix() + strconv.Itoa(dev.BrickID) + "/" + strconv.Itoa(dev.Type) + "/" + strconv.Itoa(dev.Channel) + "/set"
+}
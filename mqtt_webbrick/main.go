@@ -1,52 +1,43 @@
 package main
 
 import (
-	"encoding/json"                     // json encoding
-	"fmt"                               // For outputting messages
-	"github.com/paulcull/go-webbrick"   // For controlling  stuff
-	"github.com/yosssi/gmq/mqtt"        //mqtt libraries
-	"github.com/yosssi/gmq/mqtt/client" //mqtt libraries
-	"os"                                // For OS Interaction
-	"os/signal"                         // For picking up the signal
+	"context"                         // For stopping the webbrick receive loop
+	"encoding/json"                   // json encoding
+	"fmt"                             // For outputting messages
+	"github.com/paulcull/go-webbrick" // For controlling  stuff
+	"os"                              // For OS Interaction
+	"os/signal"                       // For picking up the signal
 	"strconv"
-	"strings"
 	"syscall" // Pick up for when running as systemctl service
 	"time"
 )
 
-// No config provided? Set up some defaults
-func defaultConfig() *webbrick.WebbrickDriverConfig {
-
-	// Set the default Configuration
-	return &webbrick.WebbrickDriverConfig{
-		Name:            "PKHome",
-		Initialised:     false,
-		NumberOfDevices: 0,
-		PollingMinutes:  5,
-		PollingActive:   false,
-	}
-}
-
-func mqttConfig() *client.ConnectOptions {
-	return &client.ConnectOptions{
-		Network:  "tcp",
-		Address:  "localhost:1883",
-		ClientID: []byte("webbrickBridge"),
-	}
-}
-
 ////////////////////
 // main proc
 ////////////////////
+// 0. loads the config, see config.go
 // 1. sets up a catch for interrupts
-// 2. connects to mqtt broker
+// 2. connects to mqtt broker, and keeps reconnecting and subscribing to the inbound messages, see broker.go
 // 3. creates a heartbeat provider
 // 4. set the redirect for interrupts
-// 5. subscribe to the inbound message channels
-// 6. connect to webbrick library and listen for events
+// 5. connect to webbrick library and listen for events - these queue up while the broker is away
 ////////////////////
 func main() {
 
+	////////////////////
+	// Work out the config
+	cfg, err := loadConfig(os.Args[1:])
+	if err != nil {
+		fmt.Println("Config:", err)
+		os.Exit(2)
+	}
+	bridge = cfg
+	connectOpts, err := bridge.connectOptions()
+	if err != nil {
+		fmt.Println("Config:", err)
+		os.Exit(2)
+	}
+
 	////////////////////
 	// Set up channel on which to send signal notifications.
 	sigc := make(chan os.Signal, 1)
@@ -54,73 +45,57 @@ func main() {
 		syscall.SIGTERM, syscall.SIGHUP, syscall.SIGINT, syscall.SIGQUIT)
 
 	////////////////////
-	//Create an MQTT Subscribe Client.
-	fmt.Println("Setting up mqtt client...")
-	cli := client.New(&client.Options{
-		// Define the processing of the error handler.
-		ErrorHandler: func(err error) {
-			fmt.Println(err)
-		},
-	})
+	// the webbrick receive loop and the broker connection run until we cancel them
+	ctx, cancel := context.WithCancel(context.Background())
 
 	////////////////////
-	// Connect to the MQTT Server.
-	fmt.Println("Setting up mqtt client...Connecting...")
-	err := cli.Connect(mqttConfig())
-	if err != nil {
-		fmt.Println(err)
-		panic(err)
-	} else {
-		fmt.Println("Setting up mqtt client...Connecting...done")
-	}
+	// Connect to the MQTT Server, in the background as we keep on at it until it's there
+	fmt.Println("Setting up mqtt client...")
+	var cli *mqttConn
+	cli = newMQTTConn(connectOpts, func(topicName, message string) {
+		actOnMessage(cli, topicName, message)
+	})
+	go cli.run(ctx)
 
 	////////////////////
 	// setup a hearbeat service publisher
 	ping := func() {
-		sent, err := publishMessage(cli, "Alive", "webbrick/bridge/heartbeat")
-		if err != nil && sent == false {
-			fmt.Println(" !!!!!!!!!!!!!!!!! Error in publishMessage")
-			panic(err)
+		// no use queueing these, they'd only say we were alive back then
+		if sent, err := cli.publish(queuedMessage{Topic: topic("bridge", "heartbeat"), Message: "Alive"}, false); err != nil && sent == false {
+			fmt.Println(" !!!!!!!!!!!!!!!!! Error in publishMessage", err)
 		}
 	}
-	heartbeat := setInterval(ping, 59*time.Second)
+	heartbeat := setInterval(ping, bridge.heartbeat())
 
 	////////////////////
 	// catch the exit signal and tidy up connections cleanly
 	go func() {
 		<-sigc
+		cancel()
 		cleanup(cli, heartbeat)
 		os.Exit(1)
 	}()
 
-	////////////////////
-	// Subscribe to topics.
-	fmt.Println("Setting up mqtt client...Subscribing...")
-	err = cli.Subscribe(&client.SubscribeOptions{
-		SubReqs: []*client.SubReq{
-			&client.SubReq{
-				TopicFilter: []byte("webbrick/to/#"),
-				QoS:         mqtt.QoS1,
-				Handler: func(topicName, message []byte) {
-					// fmt.Println(string(topicName), string(message))
-					actOnMessage(topicName, message)
-				},
-			},
-		},
-	})
-	if err != nil {
-		panic(err)
-	}
-	fmt.Println("Setting up mqtt client...Subscribing...done")
-
 	////////////////////
 	// connect to webbrick library
-	ready, err := webbrick.Prepare(defaultConfig()) // You ready?
-	if ready == true {                              // Yep! Let's do this!
-		for { // Loop forever
+	ready, err := webbrick.Prepare(bridge.driverConfig()) // You ready?
+	if ready == true {                                    // Yep! Let's do this!
+		// Block rather than drop, a lost button press is a light that doesn't come on. The
+		// receive loop waits for us if we fall that far behind
+		events, stopEvents := webbrick.Subscribe(webbrick.EventFilter{Policy: webbrick.Block, Buffer: 500})
+		defer stopEvents()
+		running := make(chan error, 1)
+		go func() {
+			running <- webbrick.Run(ctx) // Reads UDP messages until we cancel
+		}()
+	loop:
+		for { // Loop until the receive loop stops
 			fmt.Println((" *** In the loop waiting for UDP messages..."))
-			select { // This lets us do non-blocking channel reads. If we have a message, process it. If not, check for UDP data and loop
-			case msg := <-webbrick.Events:
+			select {
+			case err := <-running:
+				fmt.Println("Error:", err)
+				break loop
+			case msg := <-events:
 				fmt.Println(" **** Event for ", msg.Name, "received from... ", msg.DeviceInfo.IP.String())
 				strMsgJSON, _ := json.Marshal(msg)
 				strMsg := string(strMsgJSON)
@@ -132,25 +107,24 @@ func main() {
 					_msg = strconv.FormatBool(msg.DeviceInfo.State)
 				}
 				//sent, err := publishMessage(cli, strMsg, "webbrick/from/"+
-				sent, err := publishMessage(cli, _msg, "webbrick/from/"+
-					strconv.Itoa(msg.DeviceInfo.BrickID)+ //Brick Node
-					"/"+
-					strconv.Itoa(msg.DeviceInfo.Type)+ // Type ID
-					"/"+
-					strconv.Itoa(msg.DeviceInfo.Channel)) //+ // type channel
+				sent, err := publishMessage(cli, _msg, fromTopic(msg.DeviceInfo)) // <prefix>/from/<brick>/<type>/<channel>
 				// "/"+
 				// msg.DeviceInfo.DevID)
 				if err != nil && sent == false {
-					fmt.Println(" !!!!!!!!!!!!!!!!! Error in publishMessage")
-					panic(err)
+					fmt.Println(" !!!!!!!!!!!!!!!!! Error in publishMessage", err)
 				}
 				fmt.Println(sent)
-				if msg.Name == "newwebbrickfound" { // if its a new webbrick - then go and get all the details
-					webbrick.PollWBStatus(msg.DeviceInfo.DevID)
+				if msg.Kind == webbrick.EventDiscovered && msg.DeviceInfo.Type == webbrick.HEARTBEAT { // if its a new webbrick - then go and get all the details
+					go pollBrick(msg.DeviceInfo.DevID) // off the loop, a slow brick would hold up everything else
+				}
+				publishState(cli, msg)                 // retained json state
+				publishAvailability(cli, msg)          // is the brick up
+				publishPress(cli, msg)                 // a button was pressed
+				if msg.Kind == webbrick.EventRemoved { // brick decommissioned - take it out of Home Assistant
+					removeDevice(cli, msg.DeviceInfo)
+				} else { // tell Home Assistant about it, or about its new name
+					announceDevice(cli, msg.DeviceInfo)
 				}
-			default:
-				//fmt.Println(" **** Checking for messages ****")
-				webbrick.CheckForMessages()
 			}
 
 		}
@@ -164,40 +138,27 @@ func main() {
 	//<-sigc
 }
 
-func actOnMessage(topicName, message []byte) {
-
-	fmt.Println(" **************************** in actOnMessage ***")
-	fmt.Println(string(topicName))
-	inboundDev := string(topicName)[strings.LastIndex(string(topicName), "/")+1 : len(string(topicName))]
-	fmt.Println(inboundDev)
-	fmt.Println(string(message))
-	fmt.Println(" **************************** in actOnMessage ***")
-
+// pollBrick gets all the details of a new webbrick. Without polling on this can take as long
+// as the brick does to answer, so it runs on its own goroutine
+func pollBrick(devID string) {
+	if _, err := webbrick.PollWBStatus(devID); err != nil {
+		fmt.Println(" !!!!!!!!!!!!!!!!! Error getting status for", devID, err)
+	}
 }
 
-func publishMessage(cli *client.Client, message string, topic string) (bool, error) {
+// publishMessage sends straight away if it can, and queues it up if not
+func publishMessage(cli *mqttConn, message string, topic string) (bool, error) {
 	fmt.Println("Setting up mqtt client publish...Publishing...", topic, message)
-	//err := p_cli.Publish(&client.PublishOptions{
-	err := cli.Publish(&client.PublishOptions{
-		QoS:       mqtt.QoS0,
-		TopicName: []byte(topic),
-		Message:   []byte(message),
-	})
-	//check for err
-	if err != nil {
-		fmt.Println(" **** error in trying to publish ****")
-		fmt.Println(topic)
-		fmt.Println(message)
-		fmt.Println(" **** error in trying to publish ****")
-		return false, err
-		panic(err)
-	}
-	fmt.Println("Setting up mqtt client publish...Publishing...done")
-	//true status
-	return true, nil
+	return cli.publish(queuedMessage{Topic: topic, Message: message}, true)
 }
 
-func cleanup(cli *client.Client, heartbeat chan bool) {
+// publishRetained is for state the broker should hold on to for anyone who subscribes later
+func publishRetained(cli *mqttConn, message string, topic string) (bool, error) {
+	fmt.Println("Setting up mqtt client publish...Publishing retained...", topic, message)
+	return cli.publish(queuedMessage{Topic: topic, Message: message, Retain: true}, true)
+}
+
+func cleanup(cli *mqttConn, heartbeat chan bool) {
 
 	////////////////////
 	// stop heartbear
@@ -206,31 +167,9 @@ func cleanup(cli *client.Client, heartbeat chan bool) {
 	webbrick.ListDevices()
 
 	////////////////////
-	// unsubscribe topics
-	fmt.Println("******** Setting up mqtt client...Unsubscribing...")
-	err := cli.Unsubscribe(&client.UnsubscribeOptions{
-		TopicFilters: [][]byte{
-			[]byte("webbrick/to/#"),
-		},
-	})
-	if err != nil {
-		panic(err)
-	}
-	fmt.Println("******** Setting up mqtt client...Unsubscribing...done")
-
-	////////////////////
-	// Disconnect the Network Connection.
-	fmt.Println("******** Setting up mqtt client...Dis-connecting...")
-	if err := cli.Disconnect(); err != nil {
-		panic(err)
-	}
-	fmt.Println("******** Setting up mqtt client...Dis-connecting...done")
-
-	////////////////////
-	// Terminate theclient.
-	fmt.Println("******** Setting up mqtt client...Terminating...")
-	cli.Terminate()
-	fmt.Println("******** Setting up mqtt client...Terminating...done")
+	// say we're going, unsubscribe and disconnect - anything still queued stays in the queue file
+	cli.close()
+	fmt.Println("******** Setting up mqtt client...done")
 
 }
 
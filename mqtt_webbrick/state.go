@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"                   // For the state payloads
+	"fmt"                             // For outputting messages
+	"github.com/paulcull/go-webbrick" // For controlling  stuff
+	"strconv"                         // For topic construction
+	"sync"                            // For guarding brick availability
+	"time"                            // For timestamps
+)
+
+////////////////////
+// Retained state and availability
+////////////////////
+// Every device's state is kept, retained, as JSON on
+//   webbrick/state/<brick>/<type name>/<channel>     e.g. webbrick/state/2/light/0
+// The bridge's own availability is on webbrick/bridge/availability, with a last will
+// of "offline" so it drops when we do. Each brick's is on
+//   webbrick/state/<brick>/availability
+// going online with its heartbeats and offline when the library says it has gone quiet.
+// States are only retained if retain_state is set, which it is by default.
+////////////////////
+
+// stateStub is where the states go, webbrick/state/ unless the prefix is changed
+func stateStub() string { return topic("state") + "/" }
+
+// bridgeAvailability is where the bridge says whether it is up
+func bridgeAvailability() string { return topic("bridge", "availability") }
+
+const (
+	online  = "online"
+	offline = "offline"
+)
+
+// deviceState is the retained JSON for a device
+type deviceState struct {
+	DevID       string    `json:"device_id"`
+	Name        string    `json:"name"`
+	Type        string    `json:"type"`
+	State       bool      `json:"state"`
+	Level       float64   `json:"level"`
+	Unit        string    `json:"unit,omitempty"`
+	LastMessage string    `json:"last_message"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// brickUp is the last availability we published for each brick
+var brickUp = struct {
+	sync.Mutex
+	bricks map[int]string
+}{bricks: make(map[int]string)}
+
+// publishState keeps the device's retained state up to date
+func publishState(cli *mqttConn, msg webbrick.EventStruct) {
+
+	dev := msg.DeviceInfo
+	if dev.Type == webbrick.HEARTBEAT {
+		return // Bricks show up through their availability instead
+	}
+
+	publish := publishRetained
+	if !bridge.RetainState {
+		publish = publishMessage
+	}
+
+	if msg.Kind == webbrick.EventRemoved {
+		if !bridge.RetainState {
+			return // Nothing held to clear
+		}
+		if sent, err := publish(cli, "", stateTopic(dev)); err != nil && sent == false {
+			fmt.Println(" !!!!!!!!!!!!!!!!! Error clearing state for", dev.DevID, err)
+		}
+		return
+	}
+
+	state := deviceState{
+		DevID:       dev.DevID,
+		Name:        displayName(dev),
+		Type:        webbrick.DeviceTypeName(dev.Type),
+		State:       dev.State,
+		Level:       dev.Level,
+		Unit:        unitOf(dev.Type),
+		LastMessage: dev.LastMessage,
+		Timestamp:   msg.Time,
+	}
+	strState, _ := json.Marshal(state)
+
+	if sent, err := publish(cli, string(strState), stateTopic(dev)); err != nil && sent == false {
+		fmt.Println(" !!!!!!!!!!!!!!!!! Error publishing state for", dev.DevID, err)
+	}
+}
+
+// publishAvailability follows the bricks coming and going
+func publishAvailability(cli *mqttConn, msg webbrick.EventStruct) {
+
+	var status string
+	switch {
+	case msg.Kind == webbrick.EventOffline:
+		status = offline
+	case msg.Kind == webbrick.EventOnline, msg.DeviceInfo.Type == webbrick.HEARTBEAT && msg.Kind != webbrick.EventRemoved:
+		status = online
+	case msg.Kind == webbrick.EventRemoved && msg.DeviceInfo.Type == webbrick.HEARTBEAT:
+		status = "" // Clear it
+	default:
+		return
+	}
+
+	brick := msg.DeviceInfo.BrickID
+
+	brickUp.Lock()
+	last, seen := brickUp.bricks[brick]
+	if status == "" {
+		delete(brickUp.bricks, brick)
+	} else {
+		brickUp.bricks[brick] = status
+	}
+	brickUp.Unlock()
+
+	if seen && last == status {
+		return
+	}
+
+	if sent, err := publishRetained(cli, status, brickAvailabilityTopic(brick)); err != nil && sent == false {
+		fmt.Println(" !!!!!!!!!!!!!!!!! Error publishing availability for brick", brick, err)
+	}
+}
+
+// stateTopic is where a device's JSON state lives
+func stateTopic(dev webbrick.Device) string {
+	return stateStub() + strconv.Itoa(dev.BrickID) + "/" + webbrick.DeviceTypeName(dev.Type) + "/" + strconv.Itoa(dev.Channel)
+}
+
+// brickAvailabilityTopic is where a brick says whether it is up
+func brickAvailabilityTopic(brick int) string {
+	return stateStub() + strconv.Itoa(brick) + "/availability"
+}
+
+// unitOf is the unit a device type's level is in
+func unitOf(devType int) string {
+	switch devType {
+	case webbrick.TEMP:
+		return "°C"
+	case webbrick.LIGHT:
+		return "%"
+	}
+	return ""
+}
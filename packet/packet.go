@@ -0,0 +1,262 @@
+// Package packet decodes and encodes the UDP packets WebBricks send to each other,
+// and to us, on port 2552.
+//
+// Every packet is 13 bytes, or 14 when it carries a checksum:
+//
+//	0      length of the packet in bytes
+//	1      class, 'G' for general events and 'R' for remote packets aimed at another brick
+//	2-3    type, e.g. "ST", "CT", "AO", "TD" (bricks send some in mixed case, e.g. "Td")
+//	4      source channel (hours on ST packets)
+//	5      target channel (minutes on ST packets)
+//	6      action in the low nibble, dwell in the high nibble (seconds * 2 on ST packets)
+//	7      from node, the number of the brick that sent it
+//	8      to node, the brick a remote packet is for
+//	9      set point (day of the week on ST packets)
+//	10     spare
+//	11     value, e.g. the level on AO packets
+//	12     low byte of the value on CT packets, which is signed and in 1/16ths of a degree
+//	13     checksum, the low byte of the sum of bytes 0 to 12
+package packet
+
+import (
+	"errors"  // For crafting our own errors
+	"fmt"     // For wrapping errors
+	"strconv" // For building UIDs
+	"strings" // For upper case conversion
+)
+
+// Errors returned by Decode and Encode
+var (
+	ErrShort       = errors.New("packet: too short")
+	ErrLength      = errors.New("packet: length byte doesn't match packet")
+	ErrChecksum    = errors.New("packet: bad checksum")
+	ErrClass       = errors.New("packet: unknown class")
+	ErrUnknownType = errors.New("packet: unknown type")
+	ErrRange       = errors.New("packet: field out of range")
+)
+
+// Sizes of the two packet forms
+const (
+	Length         = 13
+	ChecksumLength = 14
+)
+
+// Class is the packet class in byte 1
+type Class byte
+
+const (
+	ClassGeneral Class = 'G' // An event the brick is telling everyone about
+	ClassRemote  Class = 'R' // A remote trigger aimed at another brick
+)
+
+// Type is the two character packet type in bytes 2 and 3, always upper case once decoded
+type Type string
+
+const (
+	TypeTime             Type = "ST" // Time stamp, the brick's heartbeat
+	TypeTemperature      Type = "CT" // Temperature reading
+	TypeAnalogueOutput   Type = "AO" // Analogue output level, e.g. a dimmer
+	TypeAnalogueInput    Type = "AI" // Analogue input reading
+	TypeDigitalOutput    Type = "DO" // Digital output state
+	TypeDigitalInput     Type = "DI" // Digital input state
+	TypeDigitalTrigger   Type = "TD" // Digital input trigger, a button or PIR
+	TypeTempTrigger      Type = "TT" // Temperature threshold trigger
+	TypeAnalogueTrigger  Type = "TA" // Analogue input threshold trigger
+	TypeScheduledTrigger Type = "TS" // Scheduled event trigger
+	TypeRemoteTrigger    Type = "TR" // Trigger sent on from another brick
+	TypeAttention        Type = "NN" // Attention, a brick announcing itself or being asked to
+)
+
+// Actions carried in the low nibble of byte 6. Remote packets use them to say what to do
+// to the target channel
+const (
+	ActionNone        = 0
+	ActionOff         = 1
+	ActionOn          = 2
+	ActionMomentary   = 3
+	ActionToggle      = 4
+	ActionDwell       = 5 // On for the dwell time, then off
+	ActionDwellCancel = 6
+	ActionNext        = 7
+	ActionPrev        = 8
+	ActionSetLevel    = 9 // Set an analogue output to the value
+)
+
+var knownTypes = map[Type]bool{
+	TypeTime: true, TypeTemperature: true, TypeAnalogueOutput: true, TypeAnalogueInput: true,
+	TypeDigitalOutput: true, TypeDigitalInput: true, TypeDigitalTrigger: true, TypeTempTrigger: true,
+	TypeAnalogueTrigger: true, TypeScheduledTrigger: true, TypeRemoteTrigger: true, TypeAttention: true,
+}
+
+// Time is the clock carried by an ST packet
+type Time struct {
+	Hour   int
+	Minute int
+	Second int
+	Day    int // Day of the week
+}
+
+// Packet is a decoded WebBrick UDP packet
+type Packet struct {
+	Class      Class
+	Type       Type
+	SrcChannel int
+	TgtChannel int
+	Action     int // 0-15
+	Dwell      int // 0-15
+	FromNode   int
+	ToNode     int
+	SetPoint   int
+	Value      int  // Byte 11, or bytes 11 and 12 on CT packets
+	Spare      int  // Byte 10, which we don't use but keep so packets encode as they came
+	Low        int  // Byte 12 on packets other than CT, kept for the same reason
+	Time       Time // Only for ST packets
+	Checksum   bool // Carries the checksum byte
+}
+
+// UID is the device ID the packet is about, e.g. "2::AO::1"
+func (p Packet) UID() string {
+	return strconv.Itoa(p.FromNode) + "::" + string(p.Type) + "::" + strconv.Itoa(p.SrcChannel)
+}
+
+// Known is true for the packet types we understand
+func (t Type) Known() bool {
+	return knownTypes[t]
+}
+
+// Decode checks and decodes a packet. A packet of a type we don't know is still decoded
+// as far as the layout allows, and returned with ErrUnknownType so it can be logged
+func Decode(buf []byte) (Packet, error) {
+
+	var p Packet
+
+	if len(buf) < Length {
+		return p, fmt.Errorf("%w: %d bytes", ErrShort, len(buf))
+	}
+	if int(buf[0]) != len(buf) || len(buf) > ChecksumLength {
+		return p, fmt.Errorf("%w: says %d, got %d", ErrLength, buf[0], len(buf))
+	}
+	if len(buf) == ChecksumLength {
+		if sum := checksum(buf[:Length]); sum != buf[Length] {
+			return p, fmt.Errorf("%w: got %d, want %d", ErrChecksum, buf[Length], sum)
+		}
+		p.Checksum = true
+	}
+
+	p.Class = Class(buf[1])
+	if p.Class != ClassGeneral && p.Class != ClassRemote {
+		return p, fmt.Errorf("%w: %q", ErrClass, buf[1])
+	}
+
+	p.Type = Type(strings.ToUpper(string(buf[2:4])))
+	p.FromNode = int(buf[7])
+	p.ToNode = int(buf[8])
+
+	if p.Type == TypeTime { // Handle time message differently
+		p.Time = Time{
+			Hour:   int(buf[4]),
+			Minute: int(buf[5]),
+			Second: int(buf[6]) / 2,
+			Day:    int(buf[9]),
+		}
+	} else {
+		p.SrcChannel = int(buf[4])
+		p.TgtChannel = int(buf[5])
+		p.Action = int(buf[6] & 0x0F)
+		p.Dwell = int(buf[6] >> 4)
+		p.SetPoint = int(buf[9])
+	}
+
+	p.Spare = int(buf[10])
+	if p.Type == TypeTemperature { // Temperatures can go below zero
+		p.Value = int(int16(uint16(buf[11])<<8 | uint16(buf[12])))
+	} else {
+		p.Value = int(buf[11])
+		p.Low = int(buf[12])
+	}
+
+	if !p.Type.Known() {
+		return p, fmt.Errorf("%w: %q", ErrUnknownType, buf[2:4])
+	}
+
+	return p, nil
+}
+
+// Encode builds the bytes for a packet, with a checksum if p.Checksum is set
+func Encode(p Packet) ([]byte, error) {
+
+	if p.Class != ClassGeneral && p.Class != ClassRemote {
+		return nil, fmt.Errorf("%w: %q", ErrClass, byte(p.Class))
+	}
+	t := Type(strings.ToUpper(string(p.Type)))
+	if len(p.Type) != 2 || !t.Known() {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownType, string(p.Type))
+	}
+
+	size := Length
+	if p.Checksum {
+		size = ChecksumLength
+	}
+
+	buf := make([]byte, size)
+	buf[0] = byte(size)
+	buf[1] = byte(p.Class)
+	copy(buf[2:4], p.Type)
+
+	var err error
+	set := func(i int, v int, max int, name string) {
+		if err == nil && (v < 0 || v > max) {
+			err = fmt.Errorf("%w: %s %d", ErrRange, name, v)
+		}
+		buf[i] = byte(v)
+	}
+
+	set(7, p.FromNode, 255, "from node")
+	set(8, p.ToNode, 255, "to node")
+
+	if t == TypeTime {
+		set(4, p.Time.Hour, 23, "hour")
+		set(5, p.Time.Minute, 59, "minute")
+		set(6, p.Time.Second*2, 119, "second")
+		set(9, p.Time.Day, 7, "day")
+	} else {
+		set(4, p.SrcChannel, 255, "source channel")
+		set(5, p.TgtChannel, 255, "target channel")
+		set(6, p.Dwell<<4|p.Action, 255, "action")
+		if p.Action < 0 || p.Action > 15 || p.Dwell < 0 || p.Dwell > 15 {
+			err = fmt.Errorf("%w: action %d dwell %d", ErrRange, p.Action, p.Dwell)
+		}
+		set(9, p.SetPoint, 255, "set point")
+	}
+
+	set(10, p.Spare, 255, "spare")
+	if t == TypeTemperature {
+		if p.Value < -32768 || p.Value > 32767 {
+			err = fmt.Errorf("%w: value %d", ErrRange, p.Value)
+		}
+		buf[11] = byte(uint16(p.Value) >> 8)
+		buf[12] = byte(p.Value)
+	} else {
+		set(11, p.Value, 255, "value")
+		set(12, p.Low, 255, "low byte")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if p.Checksum {
+		buf[Length] = checksum(buf[:Length])
+	}
+
+	return buf, nil
+}
+
+// checksum is the low byte of the sum of the bytes
+func checksum(buf []byte) byte {
+	var sum byte
+	for _, b := range buf {
+		sum += b
+	}
+	return sum
+}
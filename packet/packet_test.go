@@ -0,0 +1,146 @@
+package packet
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// Packets as the bricks put them on the wire
+var wirePackets = []struct {
+	name string
+	buf  []byte
+	want Packet
+}{
+	{
+		name: "heartbeat",
+		buf:  []byte{13, 'G', 'S', 'T', 14, 35, 20, 2, 0, 3, 0, 0, 0},
+		want: Packet{Class: ClassGeneral, Type: TypeTime, FromNode: 2, Time: Time{Hour: 14, Minute: 35, Second: 10, Day: 3}},
+	},
+	{
+		name: "temperature",
+		buf:  []byte{13, 'G', 'C', 'T', 1, 0, 0, 2, 0, 0, 0, 0x01, 0x59},
+		want: Packet{Class: ClassGeneral, Type: TypeTemperature, SrcChannel: 1, FromNode: 2, Value: 345}, // 21.5625 degrees
+	},
+	{
+		name: "temperature below zero",
+		buf:  []byte{13, 'G', 'C', 'T', 0, 0, 0, 5, 0, 0, 0, 0xFF, 0xD8},
+		want: Packet{Class: ClassGeneral, Type: TypeTemperature, FromNode: 5, Value: -40}, // -2.5 degrees
+	},
+	{
+		name: "light level",
+		buf:  []byte{13, 'G', 'A', 'O', 3, 0, 0, 2, 0, 0, 0, 75, 0},
+		want: Packet{Class: ClassGeneral, Type: TypeAnalogueOutput, SrcChannel: 3, FromNode: 2, Value: 75},
+	},
+	{
+		name: "analogue input",
+		buf:  []byte{13, 'G', 'A', 'I', 0, 0, 0, 4, 0, 0, 0, 200, 0},
+		want: Packet{Class: ClassGeneral, Type: TypeAnalogueInput, FromNode: 4, Value: 200},
+	},
+	{
+		name: "button with dwell",
+		buf:  []byte{13, 'G', 'T', 'D', 6, 2, 0x35, 2, 0, 0, 0, 0, 0},
+		want: Packet{Class: ClassGeneral, Type: TypeDigitalTrigger, SrcChannel: 6, TgtChannel: 2, Action: ActionDwell, Dwell: 3, FromNode: 2},
+	},
+	{
+		name: "digital output",
+		buf:  []byte{13, 'G', 'D', 'O', 4, 0, 0, 7, 0, 0, 0, 0, 0},
+		want: Packet{Class: ClassGeneral, Type: TypeDigitalOutput, SrcChannel: 4, FromNode: 7},
+	},
+	{
+		name: "attention",
+		buf:  []byte{13, 'G', 'N', 'N', 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		want: Packet{Class: ClassGeneral, Type: TypeAttention},
+	},
+	{
+		name: "remote set level with checksum",
+		buf:  []byte{14, 'R', 'A', 'O', 0, 1, ActionSetLevel, 0, 3, 0, 0, 50, 0, 0x2F},
+		want: Packet{Class: ClassRemote, Type: TypeAnalogueOutput, TgtChannel: 1, Action: ActionSetLevel, ToNode: 3, Value: 50, Checksum: true},
+	},
+	{
+		name: "spare bytes kept",
+		buf:  []byte{13, 'G', 'D', 'I', 1, 0, 0, 2, 0, 9, 17, 1, 42},
+		want: Packet{Class: ClassGeneral, Type: TypeDigitalInput, SrcChannel: 1, FromNode: 2, SetPoint: 9, Spare: 17, Value: 1, Low: 42},
+	},
+}
+
+func TestDecode(t *testing.T) {
+	for _, tt := range wirePackets {
+		got, err := Decode(tt.buf)
+		if err != nil {
+			t.Errorf("%s: Decode error: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: Decode got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, tt := range wirePackets {
+		p, err := Decode(tt.buf)
+		if err != nil {
+			t.Errorf("%s: Decode error: %v", tt.name, err)
+			continue
+		}
+		buf, err := Encode(p)
+		if err != nil {
+			t.Errorf("%s: Encode error: %v", tt.name, err)
+			continue
+		}
+		if !bytes.Equal(buf, tt.buf) {
+			t.Errorf("%s: Encode got % x, want % x", tt.name, buf, tt.buf)
+		}
+	}
+}
+
+func TestDecodeMixedCase(t *testing.T) {
+	p, err := Decode([]byte{13, 'G', 'T', 'd', 6, 0, 0, 2, 0, 0, 0, 0, 0})
+	if err != nil {
+		t.Fatalf("Decode error: %v", err)
+	}
+	if p.Type != TypeDigitalTrigger || p.UID() != "2::TD::6" {
+		t.Errorf("Decode got type %q, UID %q, want TD and 2::TD::6", p.Type, p.UID())
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		buf  []byte
+		want error
+	}{
+		{"short", []byte{12, 'G', 'S', 'T', 0, 0, 0, 2, 0, 0, 0, 0}, ErrShort},
+		{"length byte", []byte{12, 'G', 'S', 'T', 0, 0, 0, 2, 0, 0, 0, 0, 0}, ErrLength},
+		{"too long", []byte{15, 'G', 'S', 'T', 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0}, ErrLength},
+		{"checksum", []byte{14, 'R', 'A', 'O', 0, 1, ActionSetLevel, 0, 3, 0, 0, 50, 0, 0x30}, ErrChecksum},
+		{"class", []byte{13, 'X', 'S', 'T', 0, 0, 0, 2, 0, 0, 0, 0, 0}, ErrClass},
+		{"type", []byte{13, 'G', 'Z', 'Z', 1, 0, 0, 2, 0, 0, 0, 0, 0}, ErrUnknownType},
+	}
+	for _, tt := range tests {
+		if _, err := Decode(tt.buf); !errors.Is(err, tt.want) {
+			t.Errorf("%s: Decode error %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		p    Packet
+		want error
+	}{
+		{"class", Packet{Class: 'X', Type: TypeTime}, ErrClass},
+		{"type", Packet{Class: ClassGeneral, Type: "ZZ"}, ErrUnknownType},
+		{"hour", Packet{Class: ClassGeneral, Type: TypeTime, Time: Time{Hour: 24}}, ErrRange},
+		{"action", Packet{Class: ClassRemote, Type: TypeAnalogueOutput, Action: 16}, ErrRange},
+		{"level", Packet{Class: ClassRemote, Type: TypeAnalogueOutput, Value: 256}, ErrRange},
+		{"temperature", Packet{Class: ClassGeneral, Type: TypeTemperature, Value: 40000}, ErrRange},
+	}
+	for _, tt := range tests {
+		if _, err := Encode(tt.p); !errors.Is(err, tt.want) {
+			t.Errorf("%s: Encode error %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
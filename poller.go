@@ -0,0 +1,125 @@
+package webbrick
+
+import (
+	"context"   // For stopping pollers
+	"fmt"       // For wrapping errors
+	"math/rand" // For jitter
+	"sort"      // For stable listings
+	"time"      // For the poll interval
+)
+
+// defaultPollJitter is how far each poll can drift either side of the interval, as a fraction of it
+const defaultPollJitter = 0.1
+
+// StartPolling fetches a brick's status and config straight away, then again every poll
+// interval on its own goroutine until StopPolling. devID is the brick's heartbeat device,
+// as for GetWBStatus. A brick that is already being polled is left alone
+func (c *Client) StartPolling(devID string) error {
+
+	brick, err := c.Devices.Get(devID)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.pollers[brick.BrickID]; ok {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.pollers[brick.BrickID] = cancel
+	go c.pollLoop(ctx, brick.BrickID, devID)
+
+	myLog.Infof("   **** Polling brick %d every %v", brick.BrickID, c.pollInterval())
+	return nil
+}
+
+// StopPolling stops polling a brick. It is false if the brick wasn't being polled
+func (c *Client) StopPolling(brickID int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cancel, ok := c.pollers[brickID]
+	if ok {
+		cancel()
+		delete(c.pollers, brickID)
+	}
+	return ok
+}
+
+// StopAllPolling stops polling every brick
+func (c *Client) StopAllPolling() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for brickID, cancel := range c.pollers {
+		cancel()
+		delete(c.pollers, brickID)
+	}
+}
+
+// Polling are the bricks being polled, in order
+func (c *Client) Polling() []int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	bricks := make([]int, 0, len(c.pollers))
+	for brickID := range c.pollers {
+		bricks = append(bricks, brickID)
+	}
+	sort.Ints(bricks)
+	return bricks
+}
+
+// RefreshBrick fetches a brick's status and config now, rather than waiting for the next poll
+func (c *Client) RefreshBrick(brickID int) error {
+
+	devs := c.Devices.ByBrick(brickID)
+	if len(devs) == 0 {
+		return fmt.Errorf("%w: %d", ErrUnknownBrick, brickID)
+	}
+
+	// Any of the brick's devices will do, they all carry its address
+	_, err := c.GetWBStatus(devs[0].DevID)
+	return err
+}
+
+// pollLoop is the goroutine behind StartPolling
+func (c *Client) pollLoop(ctx context.Context, brickID int, devID string) {
+
+	for {
+		myLog.Infof("   **** Polling WBStatus & Config for %s", devID)
+		c.GetWBStatus(devID)
+
+		timer := time.NewTimer(c.backoff(brickID, c.pollDelay()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// pollInterval comes from the config's PollingMinutes, falling back to PollingTime seconds
+func (c *Client) pollInterval() time.Duration {
+	if c.Config != nil && c.Config.PollingMinutes > 0 {
+		return time.Duration(c.Config.PollingMinutes) * time.Minute
+	}
+	return PollingTime * time.Second
+}
+
+// pollDelay is the interval moved a random amount either side by PollJitter, so
+// bricks found together don't all get polled together
+func (c *Client) pollDelay() time.Duration {
+
+	interval := c.pollInterval()
+	spread := time.Duration(float64(interval) * c.PollJitter)
+	if spread <= 0 {
+		return interval
+	}
+
+	return interval - spread + time.Duration(rand.Int63n(int64(2*spread)+1))
+}
@@ -0,0 +1,108 @@
+package recorder
+
+import (
+	"encoding/csv"                    // For the rows
+	"github.com/paulcull/go-webbrick" // For type names
+	"os"                              // For the files
+	"path/filepath"                   // For the file names
+	"strconv"                         // For the values
+	"sync"                            // For guarding the file
+	"time"                            // For rotating
+)
+
+// csvHeader is the first row of every file
+var csvHeader = []string{"time", "device", "brick", "channel", "type", "name", "level", "state"}
+
+// CSV writes readings to CSV files in Dir, starting a new one each period, e.g.
+// webbrick-2026-10-16.csv for daily files. Each file starts with a header row
+type CSV struct {
+	Dir    string
+	Prefix string // Defaults to "webbrick"
+	Period string // Time layout for the file names, which sets how often they rotate. Defaults to daily, "2006-01-02"
+
+	mu   sync.Mutex
+	name string // The file we're on
+	f    *os.File
+	w    *csv.Writer
+}
+
+// Write adds the readings, each to the file for its time
+func (s *CSV) Write(readings []Reading) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, reading := range readings {
+		if err := s.rotate(reading.Time); err != nil {
+			return err
+		}
+		s.w.Write([]string{
+			reading.Time.Format(time.RFC3339Nano),
+			reading.DevID,
+			strconv.Itoa(reading.BrickID),
+			strconv.Itoa(reading.Channel),
+			webbrick.DeviceTypeName(reading.Type),
+			reading.Name,
+			strconv.FormatFloat(reading.Level, 'f', -1, 64),
+			strconv.FormatBool(reading.State),
+		})
+	}
+
+	if s.w == nil { // No readings, and no file open from before
+		return nil
+	}
+	s.w.Flush()
+	return s.w.Error()
+}
+
+// Close closes the file we're on
+func (s *CSV) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.f == nil {
+		return nil
+	}
+	s.w.Flush()
+	err := s.f.Close()
+	s.f, s.w, s.name = nil, nil, ""
+	return err
+}
+
+// rotate makes sure we're writing to the file for t. Hold s.mu
+func (s *CSV) rotate(t time.Time) error {
+
+	prefix := s.Prefix
+	if prefix == "" {
+		prefix = "webbrick"
+	}
+	period := s.Period
+	if period == "" {
+		period = "2006-01-02"
+	}
+	name := filepath.Join(s.Dir, prefix+"-"+t.Format(period)+".csv")
+	if name == s.name {
+		return nil
+	}
+
+	if s.f != nil {
+		s.w.Flush()
+		s.f.Close()
+		s.f, s.w, s.name = nil, nil, ""
+	}
+
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	s.f, s.w, s.name = f, csv.NewWriter(f), name
+	if info.Size() == 0 {
+		s.w.Write(csvHeader)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package recorder
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/paulcull/go-webbrick"
+)
+
+func TestCSV(t *testing.T) {
+
+	dir := t.TempDir()
+	sink := &CSV{Dir: dir}
+
+	if err := sink.Write(nil); err != nil {
+		t.Fatalf("Write of nothing: %v", err)
+	}
+
+	at := time.Date(2026, 10, 16, 9, 30, 0, 0, time.UTC)
+	readings := []Reading{
+		{Time: at, DevID: "2::CT::0", BrickID: 2, Type: webbrick.TEMP, Name: "Hall", Level: 21.5625},
+		{Time: at.Add(time.Minute), DevID: "2::AO::1", BrickID: 2, Channel: 1, Type: webbrick.LIGHT, Level: 40, State: true},
+	}
+	if err := sink.Write(readings); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := sink.Write(nil); err != nil {
+		t.Fatalf("Write of nothing with a file open: %v", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "webbrick-2026-10-16.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "time,device,brick,channel,type,name,level,state\n" +
+		"2026-10-16T09:30:00Z,2::CT::0,2,0,temp,Hall,21.5625,false\n" +
+		"2026-10-16T09:31:00Z,2::AO::1,2,1,light,,40,true\n"
+	if string(data) != want {
+		t.Errorf("got\n%s\nwant\n%s", data, want)
+	}
+}
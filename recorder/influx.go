@@ -0,0 +1,152 @@
+package recorder
+
+import (
+	"bytes"                           // For building batches
+	"fmt"                             // For crafting our own errors
+	"github.com/paulcull/go-webbrick" // For type names
+	"io"                              // For writing out
+	"io/ioutil"                       // For error bodies
+	"net/http"                        // For the write endpoint
+	"os"                              // For the file
+	"strconv"                         // For the values
+	"strings"                         // For escaping
+	"sync"                            // For guarding the file
+	"time"                            // For the HTTP timeout
+)
+
+// DefaultMeasurement is the InfluxDB measurement readings go in, unless the sink says otherwise
+const DefaultMeasurement = "webbrick"
+
+// AppendLine adds a reading to buf as InfluxDB line protocol, e.g.
+//
+//	webbrick,brick=2,channel=1,device=2::CT::1,name=Hall,type=temp level=21.5625,state=false 1697450000000000000
+func AppendLine(buf *bytes.Buffer, measurement string, reading Reading) {
+
+	if measurement == "" {
+		measurement = DefaultMeasurement
+	}
+	buf.WriteString(measurementEscaper.Replace(measurement))
+
+	// tags in key order, as Influx likes them. Empty ones aren't allowed
+	tag := func(key, value string) {
+		if value != "" {
+			buf.WriteByte(',')
+			buf.WriteString(key)
+			buf.WriteByte('=')
+			buf.WriteString(tagEscaper.Replace(value))
+		}
+	}
+	tag("brick", strconv.Itoa(reading.BrickID))
+	tag("channel", strconv.Itoa(reading.Channel))
+	tag("device", reading.DevID)
+	tag("name", reading.Name)
+	tag("type", webbrick.DeviceTypeName(reading.Type))
+
+	buf.WriteString(" level=")
+	buf.WriteString(strconv.FormatFloat(reading.Level, 'f', -1, 64))
+	buf.WriteString(",state=")
+	buf.WriteString(strconv.FormatBool(reading.State))
+	buf.WriteByte(' ')
+	buf.WriteString(strconv.FormatInt(reading.Time.UnixNano(), 10))
+	buf.WriteByte('\n')
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "\n", "")
+	tagEscaper         = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`, "\n", "")
+)
+
+// lines is a batch as line protocol
+func lines(measurement string, readings []Reading) []byte {
+	var buf bytes.Buffer
+	for _, reading := range readings {
+		AppendLine(&buf, measurement, reading)
+	}
+	return buf.Bytes()
+}
+
+////////////////////
+// File
+////////////////////
+
+// InfluxFile appends line protocol to a file, for loading with influx write or Telegraf's tail input
+type InfluxFile struct {
+	Measurement string // Defaults to DefaultMeasurement
+
+	mu sync.Mutex
+	w  io.WriteCloser
+}
+
+// NewInfluxFile opens, or creates, the file to append to
+func NewInfluxFile(path string) (*InfluxFile, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &InfluxFile{w: f}, nil
+}
+
+// Write appends the readings
+func (s *InfluxFile) Write(readings []Reading) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.w.Write(lines(s.Measurement, readings))
+	return err
+}
+
+// Close closes the file
+func (s *InfluxFile) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.w.Close()
+}
+
+////////////////////
+// HTTP
+////////////////////
+
+// InfluxHTTP posts line protocol to an InfluxDB write endpoint, e.g.
+// http://localhost:8086/write?db=home (1.x) or
+// http://localhost:8086/api/v2/write?org=home&bucket=webbrick&precision=ns (2.x, with a Token)
+type InfluxHTTP struct {
+	URL         string
+	Token       string       // Sent as "Authorization: Token <token>", if set
+	Measurement string       // Defaults to DefaultMeasurement
+	Client      *http.Client // Defaults to one with a 10 second timeout
+}
+
+// Write posts the readings in one request
+func (s *InfluxHTTP) Write(readings []Reading) error {
+
+	req, err := http.NewRequest(http.MethodPost, s.URL, bytes.NewReader(lines(s.Measurement, readings)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.Token != "" {
+		req.Header.Set("Authorization", "Token "+s.Token)
+	}
+
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("influx write to %s failed: %s %s", s.URL, resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// Close has nothing to do
+func (s *InfluxHTTP) Close() error {
+	return nil
+}
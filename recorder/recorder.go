@@ -0,0 +1,181 @@
+// Package recorder keeps the readings a webbrick Client sees, so temperatures and
+// levels can be looked back over for months rather than just the latest in Device.Level.
+//
+// A Recorder subscribes to the client's events and hands each change in a device's
+// reading to a Sink: InfluxDB line protocol to a file (InfluxFile) or an HTTP write
+// endpoint (InfluxHTTP), or CSV files rotated by date (CSV). Unchanged values are
+// dropped, and readings can be downsampled to one per device per Interval:
+//
+//	sink, err := recorder.NewInfluxFile("/var/lib/webbrick/readings.lp")
+//	...
+//	rec := recorder.New(client, sink, recorder.Options{Interval: time.Minute})
+//	go rec.Run(ctx)
+package recorder
+
+import (
+	"context"                         // For stopping the recorder
+	"github.com/juju/loggo"           // logging
+	"github.com/paulcull/go-webbrick" // For controlling webbrick stuff
+	"math"                            // For the deadband
+	"sort"                            // For stable batches
+	"time"                            // For downsampling
+)
+
+var myLog = loggo.GetLogger("Webbrick.recorder")
+
+// subscriptionBuffer is how far the recorder can fall behind the client, a slow sink
+// loses the oldest readings rather than holding up the client
+const subscriptionBuffer = 1000
+
+// Reading is a device's value at a time
+type Reading struct {
+	Time    time.Time
+	DevID   string
+	BrickID int
+	Channel int
+	Type    int // LIGHT, TEMP etc.
+	Name    string
+	Level   float64
+	State   bool
+}
+
+// Sink is somewhere readings go. Write gets them in time order
+type Sink interface {
+	Write(readings []Reading) error
+	Close() error
+}
+
+// Options say what gets recorded
+type Options struct {
+	Types     []int         // Device types to record, defaults to TEMP, LIGHT and STATE
+	Interval  time.Duration // At most one reading per device per Interval, the latest. 0 records every change
+	MinChange float64       // Levels that move less than this since the last recorded one are unchanged
+}
+
+// Recorder passes readings from a client to a sink
+type Recorder struct {
+	Client *webbrick.Client
+	Sink   Sink
+	Opts   Options
+
+	last    map[string]Reading // Last recorded reading, by device
+	pending map[string]Reading // Latest reading not yet recorded, by device, when downsampling
+}
+
+// New builds a Recorder
+func New(c *webbrick.Client, sink Sink, opts Options) *Recorder {
+	if len(opts.Types) == 0 {
+		opts.Types = []int{webbrick.TEMP, webbrick.LIGHT, webbrick.STATE}
+	}
+	return &Recorder{
+		Client:  c,
+		Sink:    sink,
+		Opts:    opts,
+		last:    make(map[string]Reading),
+		pending: make(map[string]Reading),
+	}
+}
+
+// Run records until ctx is done, then writes out anything still pending. It doesn't close the sink
+func (r *Recorder) Run(ctx context.Context) error {
+
+	events, cancel := r.Client.Subscribe(webbrick.EventFilter{
+		Types:  r.Opts.Types,
+		Kinds:  []webbrick.EventKind{webbrick.EventDiscovered, webbrick.EventUpdated},
+		Buffer: subscriptionBuffer,
+		Policy: webbrick.DropOldest,
+	})
+	defer cancel()
+
+	var tick <-chan time.Time
+	if r.Opts.Interval > 0 {
+		// check a few times an interval, so readings aren't held much past it
+		ticker := time.NewTicker(r.Opts.Interval / 4)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			r.flush(time.Time{}) // everything
+			return ctx.Err()
+		case now := <-tick:
+			r.flush(now)
+		case ev, ok := <-events:
+			if !ok {
+				r.flush(time.Time{})
+				return nil
+			}
+			r.add(readingOf(ev))
+		}
+	}
+}
+
+// add takes a new reading, recording it now or holding it for the next flush
+func (r *Recorder) add(reading Reading) {
+
+	last, recorded := r.last[reading.DevID]
+	if recorded && r.same(last, reading) {
+		delete(r.pending, reading.DevID) // back where it was, nothing to record
+		return
+	}
+
+	if r.Opts.Interval > 0 && recorded && reading.Time.Sub(last.Time) < r.Opts.Interval {
+		r.pending[reading.DevID] = reading
+		return
+	}
+
+	delete(r.pending, reading.DevID)
+	r.write([]Reading{reading})
+}
+
+// flush records the pending readings that are due, all of them for a zero time
+func (r *Recorder) flush(now time.Time) {
+
+	var due []Reading
+	for devID, reading := range r.pending {
+		if now.IsZero() || now.Sub(r.last[devID].Time) >= r.Opts.Interval {
+			due = append(due, reading)
+			delete(r.pending, devID)
+		}
+	}
+	if len(due) == 0 {
+		return
+	}
+
+	sort.Slice(due, func(i, j int) bool { return due[i].Time.Before(due[j].Time) })
+	r.write(due)
+}
+
+// write hands readings to the sink and remembers them
+func (r *Recorder) write(readings []Reading) {
+
+	if err := r.Sink.Write(readings); err != nil {
+		myLog.Errorf("Error recording %d readings: %v", len(readings), err)
+	}
+	// remembered even if the sink failed, or a dead sink would have us resend forever
+	for _, reading := range readings {
+		r.last[reading.DevID] = reading
+	}
+}
+
+// same is true if nothing worth recording has changed
+func (r *Recorder) same(a, b Reading) bool {
+	return a.State == b.State && math.Abs(a.Level-b.Level) <= r.Opts.MinChange && a.Name == b.Name
+}
+
+// readingOf pulls the reading out of an event
+func readingOf(ev webbrick.EventStruct) Reading {
+	dev := ev.DeviceInfo
+	return Reading{
+		Time:    ev.Time,
+		DevID:   dev.DevID,
+		BrickID: dev.BrickID,
+		Channel: dev.Channel,
+		Type:    dev.Type,
+		Name:    dev.Name,
+		Level:   dev.Level,
+		State:   dev.State,
+	}
+}
@@ -0,0 +1,129 @@
+package recorder
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/paulcull/go-webbrick"
+)
+
+// fakeSink keeps what it's given
+type fakeSink struct {
+	batches [][]float64 // The levels in each Write
+}
+
+func (s *fakeSink) Write(readings []Reading) error {
+	var levels []float64
+	for _, reading := range readings {
+		levels = append(levels, reading.Level)
+	}
+	s.batches = append(s.batches, levels)
+	return nil
+}
+
+func (s *fakeSink) Close() error {
+	return nil
+}
+
+var start = time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+
+// temperature is an event for the hall sensor, secs after start
+func temperature(secs int, level float64) webbrick.EventStruct {
+	return webbrick.EventStruct{
+		Kind:       webbrick.EventUpdated,
+		Time:       start.Add(time.Duration(secs) * time.Second),
+		DeviceInfo: webbrick.Device{DevID: "2::CT::0", BrickID: 2, Type: webbrick.TEMP, Name: "Hall", Level: level},
+	}
+}
+
+func TestRecorder(t *testing.T) {
+
+	renamed := temperature(30, 20)
+	renamed.DeviceInfo.Name = "Landing"
+
+	tests := []struct {
+		name   string
+		opts   Options
+		events []webbrick.EventStruct
+		flush  []int // seconds after start to flush at, after the events
+		want   [][]float64
+	}{
+		{
+			name:   "every change",
+			events: []webbrick.EventStruct{temperature(0, 20), temperature(10, 20), temperature(20, 20.5)},
+			want:   [][]float64{{20}, {20.5}},
+		},
+		{
+			name:   "small changes ignored",
+			opts:   Options{MinChange: 0.25},
+			events: []webbrick.EventStruct{temperature(0, 20), temperature(10, 20.125), temperature(20, 19.75), temperature(30, 19.5)},
+			want:   [][]float64{{20}, {19.5}},
+		},
+		{
+			name:   "small changes add up",
+			opts:   Options{MinChange: 0.25},
+			events: []webbrick.EventStruct{temperature(0, 20), temperature(10, 20.125), temperature(20, 20.25), temperature(30, 20.375)},
+			want:   [][]float64{{20}, {20.375}},
+		},
+		{
+			name:   "rename recorded",
+			opts:   Options{MinChange: 0.25},
+			events: []webbrick.EventStruct{temperature(0, 20), renamed},
+			want:   [][]float64{{20}, {20}},
+		},
+		{
+			name:   "downsampled to the latest",
+			opts:   Options{Interval: time.Minute},
+			events: []webbrick.EventStruct{temperature(0, 20), temperature(10, 21), temperature(20, 22)},
+			flush:  []int{30, 60},
+			want:   [][]float64{{20}, {22}},
+		},
+		{
+			name:   "downsampled back where it was",
+			opts:   Options{Interval: time.Minute},
+			events: []webbrick.EventStruct{temperature(0, 20), temperature(10, 21), temperature(20, 20)},
+			flush:  []int{60},
+			want:   [][]float64{{20}},
+		},
+		{
+			name:   "past the interval straight away",
+			opts:   Options{Interval: time.Minute},
+			events: []webbrick.EventStruct{temperature(0, 20), temperature(90, 21)},
+			want:   [][]float64{{20}, {21}},
+		},
+	}
+
+	for _, tt := range tests {
+		sink := &fakeSink{}
+		r := New(nil, sink, tt.opts)
+		for _, ev := range tt.events {
+			r.add(readingOf(ev))
+		}
+		for _, secs := range tt.flush {
+			r.flush(start.Add(time.Duration(secs) * time.Second))
+		}
+		if !reflect.DeepEqual(sink.batches, tt.want) {
+			t.Errorf("%s: wrote %v, want %v", tt.name, sink.batches, tt.want)
+		}
+	}
+}
+
+func TestRunWritesPendingOnStop(t *testing.T) {
+
+	sink := &fakeSink{}
+	r := New(webbrick.NewClient(nil), sink, Options{Interval: time.Hour})
+	r.add(readingOf(temperature(0, 20)))
+	r.add(readingOf(temperature(10, 21))) // Held for the interval
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := r.Run(ctx); err != context.Canceled {
+		t.Fatalf("Run returned %v, want context.Canceled", err)
+	}
+
+	if want := [][]float64{{20}, {21}}; !reflect.DeepEqual(sink.batches, want) {
+		t.Errorf("wrote %v, want %v", sink.batches, want)
+	}
+}
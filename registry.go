@@ -0,0 +1,151 @@
+package webbrick
+
+import (
+	"errors"  // For crafting our own errors
+	"fmt"     // For wrapping errors
+	"sort"    // For stable listings
+	"strings" // For name matching
+	"sync"    // For locking the registry
+)
+
+// ErrUnknownDevice is returned when asked about a device ID we haven't discovered
+var ErrUnknownDevice = errors.New("unknown device")
+
+// DeviceRegistry holds all the Devices a Client has discovered. Everything goes through
+// its lock, and lookups hand back copies so callers can't race with the receive loop
+type DeviceRegistry struct {
+	mu      sync.RWMutex
+	devices map[string]*Device
+	count   int // How many items we've discovered
+}
+
+// NewDeviceRegistry makes an empty registry
+func NewDeviceRegistry() *DeviceRegistry {
+	return &DeviceRegistry{devices: make(map[string]*Device)}
+}
+
+// Get returns a copy of the device with the given ID
+func (r *DeviceRegistry) Get(devID string) (Device, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	d, ok := r.devices[devID]
+	if !ok {
+		return Device{}, fmt.Errorf("%w: %s", ErrUnknownDevice, devID)
+	}
+	return *d, nil
+}
+
+// Len is how many devices we know about
+func (r *DeviceRegistry) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.devices)
+}
+
+// Snapshot copies every device, keyed on the device ID
+func (r *DeviceRegistry) Snapshot() map[string]Device {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	snap := make(map[string]Device, len(r.devices))
+	for devID, d := range r.devices {
+		snap[devID] = *d
+	}
+	return snap
+}
+
+// All copies every device, in the order they were discovered
+func (r *DeviceRegistry) All() []Device {
+	return r.filter(func(d *Device) bool { return true })
+}
+
+// ByBrick returns the devices on a brick
+func (r *DeviceRegistry) ByBrick(brickID int) []Device {
+	return r.filter(func(d *Device) bool { return d.BrickID == brickID })
+}
+
+// ByType returns the devices of a type (LIGHT, PIR, TEMP etc.)
+func (r *DeviceRegistry) ByType(devType int) []Device {
+	return r.filter(func(d *Device) bool { return d.Type == devType })
+}
+
+// ByChannel returns the devices on a channel, across all bricks and types
+func (r *DeviceRegistry) ByChannel(channel int) []Device {
+	return r.filter(func(d *Device) bool { return d.Channel == channel })
+}
+
+// ByName returns the devices with a configured name, ignoring case
+func (r *DeviceRegistry) ByName(name string) []Device {
+	return r.filter(func(d *Device) bool { return strings.EqualFold(d.Name, name) })
+}
+
+// Find returns the device of a type on a given brick and channel
+func (r *DeviceRegistry) Find(brickID int, devType int, channel int) (Device, error) {
+	found := r.filter(func(d *Device) bool {
+		return d.BrickID == brickID && d.Type == devType && d.Channel == channel
+	})
+	if len(found) == 0 {
+		return Device{}, fmt.Errorf("%w: brick %d type %d channel %d", ErrUnknownDevice, brickID, devType, channel)
+	}
+	return found[0], nil
+}
+
+// filter copies out the devices that match, in the order they were discovered
+func (r *DeviceRegistry) filter(match func(d *Device) bool) []Device {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var list []Device
+	for _, d := range r.devices {
+		if match(d) {
+			list = append(list, *d)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// add stores a new device and gives it the next ID. If we've already got it we hand
+// back what we have and false
+func (r *DeviceRegistry) add(d Device) (Device, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, ok := r.devices[d.DevID]; ok {
+		return *existing, false
+	}
+	r.count++
+	d.ID = r.count
+	r.devices[d.DevID] = &d
+	return d, true
+}
+
+// update changes a device under the lock and returns a copy of the result
+func (r *DeviceRegistry) update(devID string, change func(d *Device)) (Device, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	d, ok := r.devices[devID]
+	if !ok {
+		return Device{}, fmt.Errorf("%w: %s", ErrUnknownDevice, devID)
+	}
+	change(d)
+	return *d, nil
+}
+
+// removeBrick takes every device on a brick out of the registry and hands back what it removed
+func (r *DeviceRegistry) removeBrick(brickID int) []Device {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var removed []Device
+	for devID, d := range r.devices {
+		if d.BrickID == brickID {
+			removed = append(removed, *d)
+			delete(r.devices, devID)
+		}
+	}
+	sort.Slice(removed, func(i, j int) bool { return removed[i].ID < removed[j].ID })
+	return removed
+}
@@ -0,0 +1,97 @@
+package webbrick
+
+import (
+	"context" // For stopping the receive loop
+	"errors"  // For crafting our own errors
+	"net"     // For networking stuff - for UDP
+	"time"    // For read deadlines
+)
+
+// ErrNotPrepared is returned when reading before Prepare has opened the UDP connection
+var ErrNotPrepared = errors.New("webbrick client not prepared")
+
+// readTimeout is how long a read waits before checking whether we've been cancelled
+const readTimeout = time.Second
+
+// Run reads UDP messages on its own goroutine and hands them to the message handler
+// until ctx is cancelled or the connection fails. On cancellation it returns ctx.Err(),
+// otherwise the read error that stopped it. Messages that can't be handled are logged
+// and skipped rather than stopping the loop
+func (c *Client) Run(ctx context.Context) error {
+
+	conn := c.udpConn()
+	if conn == nil {
+		return ErrNotPrepared
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.receive(ctx, conn)
+	}()
+
+	select {
+	case <-ctx.Done():
+		conn.SetReadDeadline(time.Now()) // Kick the reader out of any read in progress
+		<-done
+		return ctx.Err()
+	case err := <-done:
+		return err
+	}
+}
+
+// receive is the loop behind Run. Close can shut conn under it, the next read then fails
+func (c *Client) receive(ctx context.Context, conn *net.UDPConn) error {
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := conn.SetReadDeadline(time.Now().Add(c.ReadTimeout)); err != nil {
+			return err
+		}
+
+		msg, addr, err := readMessage(conn, c.localIP)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				continue // Nothing arrived, go round and check for cancellation
+			}
+			return err
+		}
+		if msg == nil { // It was from us
+			continue
+		}
+
+		if _, err := c.handleMessage(msg, addr); err != nil {
+			myLog.Errorf("Error handling message from %s: %v", addr, err)
+		}
+	}
+}
+
+// Close stops any pollers and the health watchdog, and shuts the UDP connection. It's safe to call while Run is
+// still going, Run then returns the read error, but cancelling Run's context first is tidier
+func (c *Client) Close() error {
+
+	c.StopAllPolling()
+
+	c.mu.Lock()
+	conn := c.conn
+	c.conn = nil
+	if c.stopHealth != nil {
+		c.stopHealth()
+		c.stopHealth = nil
+	}
+	c.mu.Unlock()
+
+	if conn == nil {
+		return nil
+	}
+	return conn.Close()
+}
+
+// udpConn is the UDP connection, nil before Prepare and after Close
+func (c *Client) udpConn() *net.UDPConn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn
+}
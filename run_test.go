@@ -0,0 +1,69 @@
+package webbrick
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCloseWhileRunning(t *testing.T) {
+
+	c := NewClient(&WebbrickDriverConfig{UDPPort: "0"})
+	if ready, err := c.Prepare(); !ready {
+		t.Skipf("can't listen for UDP here: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	running := make(chan error, 1)
+	go func() {
+		running <- c.Run(ctx)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	select {
+	case err := <-running:
+		if err == nil {
+			t.Error("Run returned nil after Close, want the read error")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run still going after Close")
+	}
+
+	if _, err := c.CheckForMessages(); err != ErrNotPrepared {
+		t.Errorf("CheckForMessages after Close got %v, want ErrNotPrepared", err)
+	}
+}
+
+func TestHealthWithoutRun(t *testing.T) {
+
+	c := NewClient(&WebbrickDriverConfig{UDPPort: "0"})
+	c.OfflineAfter = 10 * time.Millisecond
+	if ready, err := c.Prepare(); !ready {
+		t.Skipf("can't listen for UDP here: %v", err)
+	}
+	defer c.Close()
+
+	offline, cancel := c.Subscribe(EventFilter{Kinds: []EventKind{EventOffline}})
+	defer cancel()
+
+	// A heartbeat, as CheckForMessages would hand it over, and then nothing more
+	heartbeat := []byte{13, 'G', 'S', 'T', 14, 35, 20, 2, 0, 3, 0, 0, 0}
+	if _, err := c.handleMessage(heartbeat, &net.UDPAddr{IP: net.IPv4(192, 168, 1, 2)}); err != nil {
+		t.Fatalf("handleMessage: %v", err)
+	}
+
+	select {
+	case ev := <-offline:
+		if ev.DeviceInfo.BrickID != 2 {
+			t.Errorf("brick %d went offline, want 2", ev.DeviceInfo.BrickID)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("brick never went offline without Run")
+	}
+}
@@ -0,0 +1,322 @@
+package webbrick
+
+import (
+	"bytes"         // For sniffing the snapshot format
+	"encoding/json" // For JSON snapshots
+	"encoding/xml"  // For XML snapshots
+	"errors"        // For crafting our own errors
+	"fmt"           // For wrapping errors and describing changes
+	"io"            // For reading and writing snapshots
+	"net"           // For restoring to a new address
+	"os"            // For snapshot files
+	"path/filepath" // For naming snapshot files
+	"sort"          // For stable diffs
+	"strconv"       // For diff keys
+	"strings"       // For picking the format from the file name
+	"time"          // For stamping snapshots
+)
+
+// SnapshotVersion is the snapshot file format version. Bump it if the layout changes
+const SnapshotVersion = 1
+
+// ErrSnapshotVersion is returned when reading a snapshot written by a newer version, or not by us at all
+var ErrSnapshotVersion = errors.New("unsupported snapshot version")
+
+// SnapshotFormat is how a snapshot is written out
+type SnapshotFormat int
+
+const (
+	SnapshotXML SnapshotFormat = iota
+	SnapshotJSON
+)
+
+// Snapshot is a brick's config at a point in time
+type Snapshot struct {
+	XMLName xml.Name       `xml:"WebbrickSnapshot" json:"-"`
+	Version int            `xml:"version,attr" json:"version"`
+	Taken   time.Time      `xml:"taken,attr" json:"taken"`
+	BrickNo int            `xml:"brick,attr" json:"brick"`
+	IP      string         `xml:"ip,attr" json:"ip"`
+	Config  WebbrickConfig `xml:"WebbrickConfig" json:"config"`
+}
+
+// ChangeKind is how an item differs between two configs
+type ChangeKind int
+
+const (
+	ChangeAdded   ChangeKind = iota // Only in the newer config
+	ChangeRemoved                   // Only in the older config
+	ChangeChanged                   // In both, with a different setting
+)
+
+// ConfigChange is one difference between two configs. Old is empty for something
+// that was added and New is empty for something that was removed, but either can be
+// empty for a change too, e.g. a name being cleared, so go by Kind
+type ConfigChange struct {
+	Kind ChangeKind
+	Item string // e.g. "NN", "CD 3", "CT 0"
+	Old  string
+	New  string
+}
+
+func (cc ConfigChange) String() string {
+	switch cc.Kind {
+	case ChangeAdded:
+		return "+ " + cc.Item + ": " + cc.New
+	case ChangeRemoved:
+		return "- " + cc.Item + ": " + cc.Old
+	}
+	return "~ " + cc.Item + ": " + cc.Old + " -> " + cc.New
+}
+
+// Snapshot fetches a brick's config fresh and stamps it
+func (c *Client) Snapshot(brickID int) (Snapshot, error) {
+
+	ip, err := c.brickIP(brickID)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	_wbc, err := c.FetchConfig(ip)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	return Snapshot{
+		Version: SnapshotVersion,
+		Taken:   time.Now(),
+		BrickNo: brickID,
+		IP:      ip.String(),
+		Config:  _wbc,
+	}, nil
+}
+
+// BackupAll snapshots every brick we've found into dir, one file per brick named by brick
+// number and time, e.g. brick-2-20160102T150405.123.xml. Bricks we've only heard the
+// heartbeat of are fetched too. It carries on past bricks that fail and returns the files
+// it wrote along with the first error
+func (c *Client) BackupAll(dir string, format SnapshotFormat) ([]string, error) {
+
+	var written []string
+	var firstErr error
+
+	for _, brickID := range c.knownBricks() {
+		snap, err := c.Snapshot(brickID)
+		if err == nil {
+			var path string
+			if path, err = SaveSnapshot(dir, snap, format); err == nil {
+				written = append(written, path)
+			}
+		}
+		if err != nil {
+			myLog.Errorf("   **** Backing up brick %d failed: %v", brickID, err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("backing up brick %d: %w", brickID, err)
+			}
+		}
+	}
+
+	return written, firstErr
+}
+
+// RestoreSnapshot writes a snapshot's config to the brick at ip, which doesn't have to be
+// where the snapshot came from. A snapshot is a whole config, so its name, SF and mimics go too
+func (c *Client) RestoreSnapshot(snap Snapshot, ip net.IP, opts ApplyOptions) (*Command, error) {
+	opts.Fields = AllFields
+	return c.ApplyConfigTo(ip, snap.Config, opts)
+}
+
+// SaveSnapshot writes a snapshot into dir and returns the path it went to. It never
+// overwrites an earlier snapshot, if one was taken the same millisecond the new one gets
+// a -1, -2 etc. on the end. If the write fails the file is removed, so no half written
+// snapshot is left for a restore to pick up
+func SaveSnapshot(dir string, snap Snapshot, format SnapshotFormat) (string, error) {
+
+	ext := ".xml"
+	if format == SnapshotJSON {
+		ext = ".json"
+	}
+	base := filepath.Join(dir, fmt.Sprintf("brick-%d-%s", snap.BrickNo, snap.Taken.Format("20060102T150405.000")))
+
+	var f *os.File
+	var path string
+	for n := 0; f == nil; n++ {
+		path = base + ext
+		if n > 0 {
+			path = fmt.Sprintf("%s-%d%s", base, n, ext)
+		}
+		var err error
+		f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil && !os.IsExist(err) {
+			return "", err
+		}
+	}
+
+	err := WriteSnapshot(f, snap, format)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+
+	return path, nil
+}
+
+// LoadSnapshot reads a snapshot file, XML or JSON
+func LoadSnapshot(path string) (Snapshot, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	defer f.Close()
+
+	return ReadSnapshot(f)
+}
+
+// WriteSnapshot writes a snapshot out in the given format
+func WriteSnapshot(w io.Writer, snap Snapshot, format SnapshotFormat) error {
+
+	if snap.Version == 0 {
+		snap.Version = SnapshotVersion
+	}
+
+	if format == SnapshotJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(snap)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(snap); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// ReadSnapshot reads a snapshot, working out whether it is XML or JSON from the first character
+func ReadSnapshot(r io.Reader) (Snapshot, error) {
+
+	var snap Snapshot
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return snap, err
+	}
+
+	if strings.HasPrefix(string(bytes.TrimSpace(data)), "{") {
+		err = json.Unmarshal(data, &snap)
+	} else {
+		err = xml.Unmarshal(data, &snap)
+	}
+	if err != nil {
+		return snap, err
+	}
+
+	if snap.Version < 1 || snap.Version > SnapshotVersion {
+		return snap, fmt.Errorf("%w: %d", ErrSnapshotVersion, snap.Version)
+	}
+
+	return snap, nil
+}
+
+// DiffSnapshots lists what changed between two snapshots
+func DiffSnapshots(from, to Snapshot) []ConfigChange {
+	return DiffConfigs(from.Config, to.Config)
+}
+
+// DiffConfigs lists what changed between two configs, in item order. Like ConfigCommands
+// it leaves out the brick's number, address and firmware version
+func DiffConfigs(from, to WebbrickConfig) []ConfigChange {
+
+	before, after := configItems(from), configItems(to)
+
+	var changes []ConfigChange
+	for item, was := range before {
+		if now, ok := after[item]; !ok {
+			changes = append(changes, ConfigChange{Kind: ChangeRemoved, Item: item, Old: was})
+		} else if now != was {
+			changes = append(changes, ConfigChange{Kind: ChangeChanged, Item: item, Old: was, New: now})
+		}
+	}
+	for item, now := range after {
+		if _, ok := before[item]; !ok {
+			changes = append(changes, ConfigChange{Kind: ChangeAdded, Item: item, New: now})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return itemLess(changes[i].Item, changes[j].Item) })
+	return changes
+}
+
+// configItems flattens a config into descriptions keyed on item, for diffing
+func configItems(_wbc WebbrickConfig) map[string]string {
+
+	items := map[string]string{
+		"NN": _wbc.Name,
+		"SF": strconv.Itoa(_wbc.SF),
+		"MM": fmt.Sprintf("lo %d hi %d dig %d an %d fade %d", _wbc.MM.Lo, _wbc.MM.Hi, _wbc.MM.Digital, _wbc.MM.Analogue, _wbc.MM.Fade),
+	}
+	add := func(kind string, id int, desc string) {
+		items[kind+" "+strconv.Itoa(id)] = desc
+	}
+
+	for _, sr := range _wbc.SRs.SR {
+		add("SR", sr.Id, strconv.Itoa(sr.Value))
+	}
+	for _, cd := range _wbc.CDs.CD {
+		add("CD", cd.Id, fmt.Sprintf("%q opt %d, %s", cd.Name, cd.Opt, cd.Trg))
+	}
+	for _, cc := range _wbc.CCs.CC {
+		add("CC", cc.Id, fmt.Sprintf("Dm %d Ds %d Am %d Av %d", cc.DigitalMask, cc.DigitalState, cc.AnalogueMask, cc.AnalogueSet))
+	}
+	for _, cw := range _wbc.CWs.CW {
+		add("CW", cw.Id, cw.Duration().String())
+	}
+	for _, cs := range _wbc.CSs.CS {
+		add("CS", cs.Id, strconv.Itoa(cs.Value)+"%")
+	}
+	for _, ct := range _wbc.CTs.CT {
+		add("CT", ct.Id, fmt.Sprintf("%q %s, %s", ct.Name, ct.TrgL, ct.TrgH))
+	}
+	for _, ci := range _wbc.CIs.CI {
+		add("CI", ci.Id, fmt.Sprintf("%q %s, %s", ci.Name, ci.TrgL, ci.TrgH))
+	}
+	for _, ce := range _wbc.CEs.CE {
+		add("CE", ce.Id, fmt.Sprintf("%02d:%02d on %v, %s", ce.Hours, ce.Mins, ce.Weekdays(), ce.Trg))
+	}
+	for _, no := range _wbc.NOs.NO {
+		add("NO", no.Id, no.Name)
+	}
+	for _, na := range _wbc.NAs.NA {
+		add("NA", na.Id, na.Name)
+	}
+
+	return items
+}
+
+// itemLess orders items by kind, then numerically by id, so "CD 2" comes before "CD 10"
+func itemLess(a, b string) bool {
+	aKind, aID := splitItem(a)
+	bKind, bID := splitItem(b)
+	if aKind != bKind {
+		return aKind < bKind
+	}
+	return aID < bID
+}
+
+func splitItem(item string) (string, int) {
+	parts := strings.SplitN(item, " ", 2)
+	if len(parts) < 2 {
+		return item, -1
+	}
+	id, _ := strconv.Atoi(parts[1])
+	return parts[0], id
+}
@@ -0,0 +1,121 @@
+package webbrick
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiffConfigs(t *testing.T) {
+
+	from := brickConfig()
+
+	renamed := brickConfig()
+	renamed.Name = ""
+
+	moreInputs := brickConfig()
+	moreInputs.CDs.CD = append(moreInputs.CDs.CD, CD{Id: 10, Name: "Side"}, CD{Id: 2, Name: "Porch"})
+
+	fewerInputs := brickConfig()
+	fewerInputs.CDs.CD = fewerInputs.CDs.CD[:1]
+
+	dwell := brickConfig()
+	dwell.CWs.CW[0].Value = 90
+	dwell.SF = 0
+
+	// hardware settings never show up
+	moved := brickConfig()
+	moved.BrickNo = 9
+	moved.Version = "6.5"
+
+	tests := []struct {
+		name string
+		to   WebbrickConfig
+		want []ConfigChange
+	}{
+		{"same", brickConfig(), nil},
+		{"hardware", moved, nil},
+		{"name cleared", renamed, []ConfigChange{
+			{Kind: ChangeChanged, Item: "NN", Old: "Hall", New: ""},
+		}},
+		{"added, in id order", moreInputs, []ConfigChange{
+			{Kind: ChangeAdded, Item: "CD 2", New: `"Porch" opt 0, none`},
+			{Kind: ChangeAdded, Item: "CD 10", New: `"Side" opt 0, none`},
+		}},
+		{"removed", fewerInputs, []ConfigChange{
+			{Kind: ChangeRemoved, Item: "CD 1", Old: `"Back" opt 0, toggle DO 1`},
+		}},
+		{"changed", dwell, []ConfigChange{
+			{Kind: ChangeChanged, Item: "CW 0", Old: "30s", New: "1m30s"},
+			{Kind: ChangeChanged, Item: "SF", Old: "3", New: "0"},
+		}},
+	}
+
+	for _, tt := range tests {
+		got := DiffConfigs(from, tt.to)
+		if len(got) == 0 && len(tt.want) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestConfigChangeString(t *testing.T) {
+	tests := []struct {
+		cc   ConfigChange
+		want string
+	}{
+		{ConfigChange{Kind: ChangeAdded, Item: "NO 1", New: "Fan"}, "+ NO 1: Fan"},
+		{ConfigChange{Kind: ChangeRemoved, Item: "NO 1", Old: "Fan"}, "- NO 1: Fan"},
+		{ConfigChange{Kind: ChangeChanged, Item: "NN", Old: "Hall", New: ""}, "~ NN: Hall -> "},
+		{ConfigChange{Kind: ChangeChanged, Item: "NN", Old: "", New: "Hall"}, "~ NN:  -> Hall"},
+	}
+	for _, tt := range tests {
+		if got := tt.cc.String(); got != tt.want {
+			t.Errorf("%+v: got %q, want %q", tt.cc, got, tt.want)
+		}
+	}
+}
+
+func TestSaveSnapshotUnique(t *testing.T) {
+
+	dir := t.TempDir()
+	snap := Snapshot{Taken: time.Date(2016, 1, 2, 15, 4, 5, 123e6, time.UTC), BrickNo: 2, Config: brickConfig()}
+
+	var paths []string
+	for i := 0; i < 3; i++ {
+		path, err := SaveSnapshot(dir, snap, SnapshotXML)
+		if err != nil {
+			t.Fatalf("SaveSnapshot error: %v", err)
+		}
+		paths = append(paths, filepath.Base(path))
+	}
+
+	want := []string{"brick-2-20160102T150405.123.xml", "brick-2-20160102T150405.123-1.xml", "brick-2-20160102T150405.123-2.xml"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("got %q, want %q", paths, want)
+	}
+
+	back, err := LoadSnapshot(filepath.Join(dir, want[0]))
+	if err != nil {
+		t.Fatalf("LoadSnapshot error: %v", err)
+	}
+	if changes := DiffSnapshots(snap, back); len(changes) != 0 {
+		t.Errorf("snapshot changed on the way through a file: %v", changes)
+	}
+}
+
+func TestKnownBricks(t *testing.T) {
+
+	c := NewClient(&WebbrickDriverConfig{})
+	c.configs[4] = WebbrickConfig{}
+	c.Devices.add(Device{DevID: "2::ST::0", BrickID: 2, Type: HEARTBEAT})
+	c.Devices.add(Device{DevID: "4::AO::1", BrickID: 4, Type: LIGHT, Channel: 1})
+
+	if got, want := c.knownBricks(), []int{2, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
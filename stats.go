@@ -0,0 +1,143 @@
+package webbrick
+
+import (
+	"github.com/paulcull/go-webbrick/packet" // For packet types
+	"sync"                                   // For guarding the counts
+	"sync/atomic"                            // For the dropped events
+	"time"                                   // For command latency
+)
+
+// CommandBuckets are the upper bounds, in seconds, command latency is counted into
+var CommandBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Stats are running counts of what the client has been up to, for monitoring
+type Stats struct {
+	Packets        map[string]uint64 // UDP packets received, by type, e.g. "CT"
+	BadPackets     uint64            // UDP packets that wouldn't decode, unknown ones included
+	UnknownPackets uint64            // UDP packets of a type we don't know
+	DroppedEvents  uint64            // Events subscribers didn't have room for, cancelled ones too. Not the Events channel, see Dropped
+	Triggers       map[string]uint64 // PIR and button triggers, by device ID
+	Commands       CommandStats
+	Polls          map[int]PollStats // By brick number
+}
+
+// CommandStats are the commands sent to the bricks, however they went
+type CommandStats struct {
+	Count   uint64
+	Errors  uint64
+	Seconds float64  // Total time spent sending them
+	Buckets []uint64 // Commands that took no longer than each of CommandBuckets
+}
+
+// PollStats are a brick's status fetches
+type PollStats struct {
+	Succeeded uint64
+	Failed    uint64
+}
+
+// stats is where the client keeps its counts
+type stats struct {
+	mu sync.Mutex
+	Stats
+}
+
+// Stats copies the counts so far
+func (c *Client) Stats() Stats {
+	c.stats.mu.Lock()
+	defer c.stats.mu.Unlock()
+
+	s := c.stats.Stats
+	s.Packets = make(map[string]uint64, len(c.stats.Packets))
+	for t, n := range c.stats.Packets {
+		s.Packets[t] = n
+	}
+	s.Triggers = make(map[string]uint64, len(c.stats.Triggers))
+	for devID, n := range c.stats.Triggers {
+		s.Triggers[devID] = n
+	}
+	s.Polls = make(map[int]PollStats, len(c.stats.Polls))
+	for brickID, p := range c.stats.Polls {
+		s.Polls[brickID] = p
+	}
+	s.Commands.Buckets = append([]uint64(nil), c.stats.Commands.Buckets...)
+	if s.Commands.Buckets == nil {
+		s.Commands.Buckets = make([]uint64, len(CommandBuckets))
+	}
+	s.DroppedEvents = atomic.LoadUint64(&c.dropped)
+
+	return s
+}
+
+// countPacket counts a packet that decoded
+func (c *Client) countPacket(t packet.Type) {
+	c.stats.mu.Lock()
+	defer c.stats.mu.Unlock()
+
+	if c.stats.Packets == nil {
+		c.stats.Packets = make(map[string]uint64)
+	}
+	c.stats.Packets[string(t)]++
+}
+
+// countBadPacket counts a packet that didn't
+func (c *Client) countBadPacket(unknown bool) {
+	c.stats.mu.Lock()
+	defer c.stats.mu.Unlock()
+
+	c.stats.BadPackets++
+	if unknown {
+		c.stats.UnknownPackets++
+	}
+}
+
+// countTrigger counts a PIR or button going off
+func (c *Client) countTrigger(devID string) {
+	c.stats.mu.Lock()
+	defer c.stats.mu.Unlock()
+
+	if c.stats.Triggers == nil {
+		c.stats.Triggers = make(map[string]uint64)
+	}
+	c.stats.Triggers[devID]++
+}
+
+// countCommand counts a command sent at start, and how it went
+func (c *Client) countCommand(start time.Time, err error) {
+
+	took := time.Since(start).Seconds()
+
+	c.stats.mu.Lock()
+	defer c.stats.mu.Unlock()
+
+	cmds := &c.stats.Commands
+	if cmds.Buckets == nil {
+		cmds.Buckets = make([]uint64, len(CommandBuckets))
+	}
+	cmds.Count++
+	cmds.Seconds += took
+	if err != nil {
+		cmds.Errors++
+	}
+	for i, bound := range CommandBuckets {
+		if took <= bound {
+			cmds.Buckets[i]++
+		}
+	}
+}
+
+// countPoll counts a status fetch from a brick
+func (c *Client) countPoll(brickID int, err error) {
+	c.stats.mu.Lock()
+	defer c.stats.mu.Unlock()
+
+	if c.stats.Polls == nil {
+		c.stats.Polls = make(map[int]PollStats)
+	}
+	p := c.stats.Polls[brickID]
+	if err == nil {
+		p.Succeeded++
+	} else {
+		p.Failed++
+	}
+	c.stats.Polls[brickID] = p
+}
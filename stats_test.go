@@ -0,0 +1,44 @@
+package webbrick
+
+import "testing"
+
+func TestDroppedEventsNeverGoBack(t *testing.T) {
+
+	c := NewClient(&WebbrickDriverConfig{})
+	_, cancelOldest := c.Subscribe(EventFilter{Buffer: 1, Policy: DropOldest})
+	_, cancelNewest := c.Subscribe(EventFilter{Buffer: 2, Policy: DropNewest})
+	defer cancelNewest()
+
+	for n := 0; n < 4; n++ {
+		c.passEvent(numbered(n))
+	}
+	if got := c.Stats().DroppedEvents; got != 5 {
+		t.Fatalf("dropped %d, want 3 oldest and 2 newest", got)
+	}
+
+	cancelOldest()
+	if got := c.TotalDropped(); got != 2 {
+		t.Errorf("TotalDropped %d after cancel, want the 2 the live subscriber lost", got)
+	}
+	if got := c.Stats().DroppedEvents; got != 5 {
+		t.Errorf("Stats dropped %d after cancel, want still 5", got)
+	}
+}
+
+func TestUnreadEventsChannelNotCounted(t *testing.T) {
+
+	c := NewClient(&WebbrickDriverConfig{})
+	for n := 0; n < defaultSubscriberBuffer+3; n++ {
+		c.passEvent(numbered(n))
+	}
+
+	if got := c.Dropped(c.Events); got != 3 {
+		t.Errorf("Events dropped %d, want 3", got)
+	}
+	if got := c.Stats().DroppedEvents; got != 0 {
+		t.Errorf("Stats dropped %d, want 0 for an Events channel nobody reads", got)
+	}
+	if got := c.TotalDropped(); got != 0 {
+		t.Errorf("TotalDropped %d, want 0", got)
+	}
+}
@@ -0,0 +1,218 @@
+package webbrick
+
+import (
+	"sync"        // For guarding subscribers
+	"sync/atomic" // For drop counts
+)
+
+// defaultSubscriberBuffer is how many events a subscriber can fall behind by, unless its filter says
+const defaultSubscriberBuffer = 50
+
+// BufferPolicy is what happens when a subscriber's buffer is full
+type BufferPolicy int
+
+const (
+	Block      BufferPolicy = iota // Wait for the subscriber. Nothing is lost, but a stuck subscriber holds everything up
+	DropOldest                     // Throw away the oldest buffered event to make room
+	DropNewest                     // Throw away the new event
+)
+
+// EventFilter picks the events a subscriber gets. Empty lists match everything, so the
+// zero filter gets every event. Buffer and Policy say how the subscriber's channel behaves
+type EventFilter struct {
+	Bricks   []int
+	Types    []int // LIGHT, PIR, TEMP etc.
+	Channels []int
+	Kinds    []EventKind
+
+	Buffer int          // Channel size, 0 for the default of 50
+	Policy BufferPolicy // What to do when the channel is full
+}
+
+// Match is true if the event gets through the filter
+func (f EventFilter) Match(ev EventStruct) bool {
+	return matchAny(f.Bricks, ev.DeviceInfo.BrickID) &&
+		matchAny(f.Types, ev.DeviceInfo.Type) &&
+		matchAny(f.Channels, ev.DeviceInfo.Channel) &&
+		matchKind(f.Kinds, ev.Kind)
+}
+
+func matchAny(list []int, v int) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, l := range list {
+		if l == v {
+			return true
+		}
+	}
+	return false
+}
+
+func matchKind(list []EventKind, k EventKind) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, l := range list {
+		if l == k {
+			return true
+		}
+	}
+	return false
+}
+
+// subscriber is one consumer of events
+type subscriber struct {
+	ch      chan EventStruct
+	filter  EventFilter
+	done    chan struct{} // Closed on cancel, to free a blocked send
+	mu      sync.Mutex    // Held while sending, so cancel doesn't close ch under a sender
+	closed  bool
+	dropped uint64 // Atomic
+	legacy  bool   // The Events channel, see passEvent
+}
+
+// Subscribe starts a new, independent, stream of the events that match filter. Call
+// cancel when done with it; the channel is closed once any send in progress has finished
+func (c *Client) Subscribe(filter EventFilter) (<-chan EventStruct, func()) {
+
+	size := filter.Buffer
+	if size <= 0 {
+		size = defaultSubscriberBuffer
+	}
+
+	sub := &subscriber{
+		ch:     make(chan EventStruct, size),
+		filter: filter,
+		done:   make(chan struct{}),
+	}
+	c.addSubscriber(sub)
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			c.removeSubscriber(sub)
+			close(sub.done)
+
+			sub.mu.Lock()
+			sub.closed = true
+			close(sub.ch)
+			sub.mu.Unlock()
+		})
+	}
+
+	return sub.ch, cancel
+}
+
+// Dropped is how many events a subscriber has lost to a full buffer. It works for the
+// Events channel too, which drops the newest event when nobody is reading it
+func (c *Client) Dropped(ch <-chan EventStruct) uint64 {
+	c.subsMu.RLock()
+	defer c.subsMu.RUnlock()
+
+	for _, sub := range c.subs {
+		if (<-chan EventStruct)(sub.ch) == ch {
+			return atomic.LoadUint64(&sub.dropped)
+		}
+	}
+	return 0
+}
+
+// TotalDropped is how many events have been lost across every current subscriber, bar the
+// Events channel. It goes down when a subscriber that lost some is cancelled, Stats has
+// the count that never does
+func (c *Client) TotalDropped() uint64 {
+	c.subsMu.RLock()
+	defer c.subsMu.RUnlock()
+
+	var total uint64
+	for _, sub := range c.subs {
+		if !sub.legacy {
+			total += atomic.LoadUint64(&sub.dropped)
+		}
+	}
+	return total
+}
+
+func (c *Client) addSubscriber(sub *subscriber) {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	c.subs = append(c.subs, sub)
+}
+
+func (c *Client) removeSubscriber(sub *subscriber) {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+
+	for i, s := range c.subs {
+		if s == sub {
+			c.subs = append(c.subs[:i:i], c.subs[i+1:]...)
+			return
+		}
+	}
+}
+
+// passEvent hands the event to every subscriber it matches, including the Events channel.
+// Programs that Subscribe usually leave Events unread, so what it drops isn't counted
+// against the client, Dropped(Events) still has it
+func (c *Client) passEvent(ev EventStruct) bool {
+
+	c.subsMu.RLock()
+	subs := append([]*subscriber(nil), c.subs...)
+	c.subsMu.RUnlock()
+
+	for _, sub := range subs {
+		if sub.filter.Match(ev) {
+			if dropped := sub.send(ev); dropped > 0 && !sub.legacy {
+				atomic.AddUint64(&c.dropped, dropped)
+			}
+		}
+	}
+
+	return true
+}
+
+// send delivers one event according to the subscriber's policy, and says how many events it dropped
+func (sub *subscriber) send(ev EventStruct) uint64 {
+
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	if sub.closed {
+		return 0
+	}
+
+	var dropped uint64
+	switch sub.filter.Policy {
+	case Block:
+		select {
+		case sub.ch <- ev:
+		case <-sub.done:
+		}
+
+	case DropOldest:
+		for {
+			select {
+			case sub.ch <- ev:
+				atomic.AddUint64(&sub.dropped, dropped)
+				return dropped
+			default:
+			}
+			select {
+			case <-sub.ch: // Make room
+				dropped++
+			default:
+			}
+		}
+
+	default: // DropNewest
+		select {
+		case sub.ch <- ev:
+		default:
+			dropped = 1
+		}
+	}
+
+	atomic.AddUint64(&sub.dropped, dropped)
+	return dropped
+}
@@ -0,0 +1,154 @@
+package webbrick
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// numbered is an event we can tell apart by its channel
+func numbered(n int) EventStruct {
+	return EventStruct{Kind: EventUpdated, DeviceInfo: Device{BrickID: 2, Type: LIGHT, Channel: n}}
+}
+
+// drain reads what's buffered on ch
+func drain(ch <-chan EventStruct) []int {
+	var got []int
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				return got
+			}
+			got = append(got, ev.DeviceInfo.Channel)
+		default:
+			return got
+		}
+	}
+}
+
+func TestEventFilterMatch(t *testing.T) {
+
+	ev := EventStruct{Kind: EventUpdated, DeviceInfo: Device{BrickID: 2, Type: TEMP, Channel: 1}}
+
+	tests := []struct {
+		name   string
+		filter EventFilter
+		want   bool
+	}{
+		{"everything", EventFilter{}, true},
+		{"brick", EventFilter{Bricks: []int{1, 2}}, true},
+		{"other brick", EventFilter{Bricks: []int{3}}, false},
+		{"type", EventFilter{Types: []int{TEMP}}, true},
+		{"other type", EventFilter{Types: []int{LIGHT, PIR}}, false},
+		{"channel", EventFilter{Channels: []int{1}}, true},
+		{"other channel", EventFilter{Channels: []int{0}}, false},
+		{"kind", EventFilter{Kinds: []EventKind{EventDiscovered, EventUpdated}}, true},
+		{"other kind", EventFilter{Kinds: []EventKind{EventOffline}}, false},
+		{"all of them", EventFilter{Bricks: []int{2}, Types: []int{TEMP}, Channels: []int{1}, Kinds: []EventKind{EventUpdated}}, true},
+		{"all but one", EventFilter{Bricks: []int{2}, Types: []int{TEMP}, Channels: []int{1}, Kinds: []EventKind{EventRemoved}}, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.filter.Match(ev); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDropPolicies(t *testing.T) {
+
+	tests := []struct {
+		policy  BufferPolicy
+		want    []int
+		dropped uint64
+	}{
+		{DropOldest, []int{3, 4}, 3},
+		{DropNewest, []int{0, 1}, 3},
+	}
+
+	for _, tt := range tests {
+		c := NewClient(&WebbrickDriverConfig{})
+		ch, cancel := c.Subscribe(EventFilter{Buffer: 2, Policy: tt.policy})
+
+		for n := 0; n < 5; n++ {
+			c.passEvent(numbered(n))
+		}
+
+		if got := drain(ch); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("policy %d: got %v, want %v", tt.policy, got, tt.want)
+		}
+		if got := c.Dropped(ch); got != tt.dropped {
+			t.Errorf("policy %d: dropped %d, want %d", tt.policy, got, tt.dropped)
+		}
+		cancel()
+	}
+}
+
+func TestBlockPolicy(t *testing.T) {
+
+	c := NewClient(&WebbrickDriverConfig{})
+	ch, cancel := c.Subscribe(EventFilter{Buffer: 1, Policy: Block, Kinds: []EventKind{EventUpdated}})
+	defer cancel()
+
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		for n := 0; n < 3; n++ {
+			c.passEvent(numbered(n))
+		}
+	}()
+
+	select {
+	case <-sent:
+		t.Fatal("sending didn't wait for the subscriber")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	var got []int
+	for len(got) < 3 {
+		select {
+		case ev := <-ch:
+			got = append(got, ev.DeviceInfo.Channel)
+		case <-time.After(time.Second):
+			t.Fatalf("got %v, then nothing", got)
+		}
+	}
+	<-sent
+
+	if want := []int{0, 1, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if dropped := c.Dropped(ch); dropped != 0 {
+		t.Errorf("dropped %d, want 0", dropped)
+	}
+}
+
+func TestCancelFreesBlockedSend(t *testing.T) {
+
+	c := NewClient(&WebbrickDriverConfig{})
+	ch, cancel := c.Subscribe(EventFilter{Buffer: 1, Policy: Block})
+
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		c.passEvent(numbered(0))
+		c.passEvent(numbered(1)) // Blocks, nobody's reading
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("send still blocked after cancel")
+	}
+
+	if got := drain(ch); !reflect.DeepEqual(got, []int{0}) {
+		t.Errorf("got %v, want [0]", got)
+	}
+	if _, open := <-ch; open {
+		t.Error("channel still open after cancel")
+	}
+}
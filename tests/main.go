@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"                         // For stopping the receive loop
 	"fmt"                             // For outputting messages
 	"github.com/paulcull/go-webbrick" // For controlling webbrick stuff
 	"strconv"
@@ -27,35 +28,35 @@ func main() {
 	fmt.Println("**** Starting Test...2")
 	if ready == true { // Yep! Let's do this!
 		fmt.Println("**** Starting Test...3")
-		for { // Loop forever
+		running := make(chan error, 1)
+		go func() {
+			running <- webbrick.Run(context.Background()) // Reads UDP messages until the connection fails
+		}()
+	loop:
+		for { // Loop until the receive loop stops
 			fmt.Println("**** Starting Test...4")
-			select { // This lets us do non-blocking channel reads. If we have a message, process it. If not, check for UDP data and loop
+			select {
+			case err := <-running:
+				fmt.Println("Error:", err)
+				break loop
 			case msg := <-webbrick.Events:
 				fmt.Println("**** Starting Test...5")
 				fmt.Println(" **** Event for ", msg.Name, "received...")
-				switch msg.Name {
-				case "existinglightchannelfound":
+				switch msg.Kind {
+				case webbrick.EventUpdated:
 					fmt.Println("  **** "+msg.Name+" Webbrick updated - DEV ID is ", msg.DeviceInfo.DevID, " value of ", strconv.Itoa(int(msg.DeviceInfo.Level)))
-				case "existingwebbrickupdated", "existingtriggerupdated", "existingbuttonupdated":
-					fmt.Println("  **** "+msg.Name+" Webbrick updated! DEV ID is", msg.DeviceInfo.DevID)
-					//fallthrough
-				case "newlightchannelfound":
+				case webbrick.EventDiscovered:
 					fmt.Println("  **** "+msg.Name+" Webbrick found! DEV ID is ", msg.DeviceInfo.DevID, " value of ", strconv.Itoa(int(msg.DeviceInfo.Level)))
-				case "newwebbrickfound", "newtriggerfound", "newbuttonfound":
-					fmt.Println("  **** "+msg.Name+" Webbrick found! DEV ID is", msg.DeviceInfo.DevID)
-					webbrick.PollWBStatus(msg.DeviceInfo.DevID)
+					if msg.DeviceInfo.Type == webbrick.HEARTBEAT {
+						go webbrick.PollWBStatus(msg.DeviceInfo.DevID) // Can take a while, don't hold up the events
+					}
 					//orvibo.Subscribe() // Subscribe to any unsubscribed sockets
 					//orvibo.Query()     // And query any unqueried sockets
-				case "queried":
-					// fmt.Println("We've queried. Name is:", msg.DeviceInfo.Name)
-					// webbrick.SetState(msg.DeviceInfo.DevID, true)
-					// time.Sleep(time.Second)
-					// webbrick.SetState(msg.DeviceInfo.DevID, false)
-				case "statechanged":
-					fmt.Println("State changed to", msg.DeviceInfo.State)
+				case webbrick.EventOffline, webbrick.EventOnline:
+					fmt.Println("  **** Brick", msg.DeviceInfo.BrickID, "is", msg.Kind)
+				case webbrick.EventCommanded:
+					fmt.Println("State changed from", msg.Previous.State, "to", msg.Current.State)
 				}
-			default:
-				webbrick.CheckForMessages()
 			}
 
 		}
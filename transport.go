@@ -0,0 +1,201 @@
+package webbrick
+
+import (
+	"context"                                // For building requests
+	"fmt"                                    // For crafting errors
+	"github.com/paulcull/go-webbrick/packet" // For building UDP command packets
+	"io/ioutil"                              // HTTP body response processing
+	"net"                                    // For networking stuff - for UDP
+	"net/http"                               // For web http calls
+)
+
+// defaultHTTPClient is for an HTTPTransport built without a client, so it still gives up on a dead brick
+var defaultHTTPClient = &http.Client{Timeout: httpTimeout}
+
+// CommandPort is the UDP port bricks take command packets on
+const CommandPort = 2552
+
+// Transport is how commands get to a brick. Levels are 0-100
+type Transport interface {
+	SetLevel(dev Device, level int) error // Set an analogue output
+	SetOutput(dev Device, on bool) error  // Turn a digital output on or off
+	TriggerInput(dev Device) error        // Trigger a digital input, as if the button was pushed
+}
+
+//////////////////////////////////
+//
+// HTTP - hid.spi on the brick's web server
+//
+//////////////////////////////////
+
+// HTTPTransport sends commands as GETs to hid.spi on the brick's web server
+type HTTPTransport struct {
+	Client   *http.Client // nil for one that times out after 30 seconds
+	Endpoint Endpoint     // How to reach the brick, nil for Direct
+}
+
+// SetLevel sets an analogue output
+func (t *HTTPTransport) SetLevel(dev Device, level int) error {
+	return t.Send(dev.IP, NewCommand().AnalogueSet(dev.Channel, level))
+}
+
+// SetOutput turns a digital output on or off
+func (t *HTTPTransport) SetOutput(dev Device, on bool) error {
+	if on {
+		return t.Send(dev.IP, NewCommand().DigitalOn(dev.Channel))
+	}
+	return t.Send(dev.IP, NewCommand().DigitalOff(dev.Channel))
+}
+
+// TriggerInput triggers a digital input
+func (t *HTTPTransport) TriggerInput(dev Device) error {
+	return t.Send(dev.IP, NewCommand().TriggerInput(dev.Channel))
+}
+
+// Send fires a batch of commands at the brick in one request
+func (t *HTTPTransport) Send(ip net.IP, cmd *Command) error {
+	return t.SendContext(context.Background(), ip, cmd)
+}
+
+// SendContext is Send, giving up when ctx is cancelled
+func (t *HTTPTransport) SendContext(ctx context.Context, ip net.IP, cmd *Command) error {
+
+	endpoint := t.Endpoint
+	if endpoint == nil {
+		endpoint = Direct{}
+	}
+
+	// http://192.168.1.249/hid.spi?com=%3A&com=AA0%3B85&com=%3A
+	req, err := endpoint.NewRequest(ctx, ip, "/hid.spi?"+cmd.Query())
+	if err != nil {
+		return err
+	}
+	myLog.Debugf("++++++++++++ sending %s ++++++++++++", req.URL)
+
+	client := t.Client
+	if client == nil {
+		client = defaultHTTPClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Dont need to read the response yet
+	// its fire and hope and forget
+	body, _ := ioutil.ReadAll(resp.Body)
+	myLog.Debugf("**** Body Message **** %s", body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("command %s to %s failed: %s", cmd, ip, resp.Status)
+	}
+
+	return nil
+}
+
+//////////////////////////////////
+//
+// UDP - command packets on port 2552
+//
+//////////////////////////////////
+
+// UDPTransport sends commands as remote packets straight to the brick, which avoids the
+// HTTP round trip and still works when the brick's web server is busy
+type UDPTransport struct {
+	Port     int // Defaults to CommandPort
+	FromNode int // The node number we send as
+}
+
+// SetLevel sets an analogue output
+func (t *UDPTransport) SetLevel(dev Device, level int) error {
+	return t.send(dev, packet.TypeAnalogueOutput, packet.ActionSetLevel, level)
+}
+
+// SetOutput turns a digital output on or off
+func (t *UDPTransport) SetOutput(dev Device, on bool) error {
+	action := packet.ActionOff
+	if on {
+		action = packet.ActionOn
+	}
+	return t.send(dev, packet.TypeDigitalOutput, action, 0)
+}
+
+// TriggerInput triggers a digital input
+func (t *UDPTransport) TriggerInput(dev Device) error {
+	return t.send(dev, packet.TypeDigitalInput, packet.ActionMomentary, 0)
+}
+
+// send builds the packet and fires it at the brick
+func (t *UDPTransport) send(dev Device, pktType packet.Type, action int, value int) error {
+
+	buf, err := packet.Encode(packet.Packet{
+		Class:      packet.ClassRemote,
+		Type:       pktType,
+		TgtChannel: dev.Channel,
+		Action:     action,
+		FromNode:   t.FromNode,
+		ToNode:     dev.BrickID,
+		Value:      value,
+	})
+	if err != nil {
+		return err
+	}
+
+	port := t.Port
+	if port == 0 {
+		port = CommandPort
+	}
+
+	conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: dev.IP, Port: port})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	myLog.Debugf("++++++++++++ sending % x to %s ++++++++++++", buf, conn.RemoteAddr())
+	_, err = conn.Write(buf)
+	return err
+}
+
+//////////////////////////////////
+//
+// Picking the transport for a brick
+//
+//////////////////////////////////
+
+// SetTransport picks the transport for one brick. A nil transport puts it back on the default
+func (c *Client) SetTransport(brickID int, t Transport) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if t == nil {
+		delete(c.transports, brickID)
+		return
+	}
+	c.transports[brickID] = t
+}
+
+// UseUDP sends a brick's commands over UDP rather than HTTP
+func (c *Client) UseUDP(brickID int) {
+	c.SetTransport(brickID, &UDPTransport{})
+}
+
+// httpTransport is for the commands only hid.spi understands
+func (c *Client) httpTransport() *HTTPTransport {
+	return &HTTPTransport{Client: c.httpClient, Endpoint: c.endpoint()}
+}
+
+// transportFor is the transport a brick's commands go over
+func (c *Client) transportFor(brickID int) Transport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if t, ok := c.transports[brickID]; ok {
+		return t
+	}
+	if c.Transport == nil {
+		return c.httpTransport()
+	}
+	return c.Transport
+}
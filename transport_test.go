@@ -0,0 +1,56 @@
+package webbrick
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// gatewayTo points a Gateway at a test server
+func gatewayTo(t *testing.T, srv *httptest.Server) *Gateway {
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, _ := strconv.Atoi(u.Port())
+	return &Gateway{Host: u.Hostname(), Port: port}
+}
+
+func TestHTTPTransportSendContext(t *testing.T) {
+
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/wbproxy/10.100.100.99/") {
+			<-r.Context().Done() // A brick that's stopped answering
+			return
+		}
+		got = r.URL.RequestURI()
+	}))
+	defer srv.Close()
+
+	tr := &HTTPTransport{Endpoint: gatewayTo(t, srv)} // No client of its own
+	ip := net.IPv4(10, 100, 100, 101)
+
+	if err := tr.Send(ip, NewCommand().DigitalOn(3)); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if want := "/wbproxy/10.100.100.101/hid.spi?" + NewCommand().DigitalOn(3).Query(); got != want {
+		t.Errorf("sent %s, want %s", got, want)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := tr.SendContext(ctx, net.IPv4(10, 100, 100, 99), NewCommand().DigitalOn(3)); err == nil {
+		t.Error("SendContext to a hung brick got no error")
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("SendContext took %s to give up, want it to stop on cancel", waited)
+	}
+}
@@ -0,0 +1,215 @@
+package webbrick
+
+import (
+	"fmt"                                    // For building descriptions
+	"github.com/paulcull/go-webbrick/packet" // For the action codes
+)
+
+//////////////////////////////////
+//
+// Trigger decoding
+//
+// Every trigger (Trg, TrgL, TrgH) is four bytes:
+//
+//	B1  bits 6-7 target type, bits 4-5 options, bits 0-3 action
+//	B2  bits 5-7 dwell time (CW) index, bits 0-4 channel
+//	B3  target node for remote actions, preset level (CS) index otherwise
+//	B4  UDP packet type to send when the trigger fires, 0 for none
+//
+//////////////////////////////////
+
+// TargetType is what a trigger acts on
+type TargetType int
+
+const (
+	TargetDigital  TargetType = iota // A digital output (DO)
+	TargetAnalogue                   // An analogue output (AO)
+	TargetScene                      // A scene (CC)
+	TargetRemote                     // A channel on another brick
+)
+
+// ActionKind is what a trigger does to its target. The values are the same as the
+// actions in UDP packets
+type ActionKind int
+
+const (
+	ActionNone        ActionKind = packet.ActionNone
+	ActionOff         ActionKind = packet.ActionOff
+	ActionOn          ActionKind = packet.ActionOn
+	ActionMomentary   ActionKind = packet.ActionMomentary
+	ActionToggle      ActionKind = packet.ActionToggle
+	ActionDwell       ActionKind = packet.ActionDwell
+	ActionDwellCancel ActionKind = packet.ActionDwellCancel
+	ActionNext        ActionKind = packet.ActionNext
+	ActionPrev        ActionKind = packet.ActionPrev
+	ActionSetLevel    ActionKind = packet.ActionSetLevel
+)
+
+var targetNames = map[TargetType]string{
+	TargetDigital:  "DO",
+	TargetAnalogue: "AO",
+	TargetScene:    "scene",
+	TargetRemote:   "remote",
+}
+
+var actionNames = map[ActionKind]string{
+	ActionNone:        "none",
+	ActionOff:         "off",
+	ActionOn:          "on",
+	ActionMomentary:   "momentary",
+	ActionToggle:      "toggle",
+	ActionDwell:       "dwell",
+	ActionDwellCancel: "cancel dwell",
+	ActionNext:        "next",
+	ActionPrev:        "previous",
+	ActionSetLevel:    "set level",
+}
+
+func (t TargetType) String() string {
+	if name, ok := targetNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("target(%d)", int(t))
+}
+
+func (k ActionKind) String() string {
+	if name, ok := actionNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("action(%d)", int(k))
+}
+
+// Action is a decoded trigger
+type Action struct {
+	Target   TargetType
+	Kind     ActionKind
+	Node     int  // The brick a remote action goes to, 0 for local actions
+	Channel  int  // The output, scene or remote channel
+	Dwell    int  // Dwell time (CW) index, for dwell actions
+	SetPoint int  // Preset level (CS) index, for set level actions
+	UDPSend  bool // Send a UDP packet when the trigger fires, set when UDPType isn't 0
+	UDPType  int  // B4 as is
+	Options  int  // B1 bits 4-5 as is
+}
+
+// DecodeTrigger turns the four trigger bytes into an Action
+func DecodeTrigger(b1, b2, b3, b4 int) Action {
+
+	a := Action{
+		Target:  TargetType(b1 >> 6 & 0x03),
+		Options: b1 >> 4 & 0x03,
+		Kind:    ActionKind(b1 & 0x0F),
+		Dwell:   b2 >> 5 & 0x07,
+		Channel: b2 & 0x1F,
+		UDPSend: b4 != 0,
+		UDPType: b4,
+	}
+
+	if a.Target == TargetRemote {
+		a.Node = b3
+	} else {
+		a.SetPoint = b3
+	}
+
+	return a
+}
+
+// Encode turns the Action back into the four trigger bytes. Byte 3 is the Node for a remote
+// target and the SetPoint otherwise, so setting the other one is an error
+func (a Action) Encode() (Trg, error) {
+
+	switch {
+	case a.Target < TargetDigital || a.Target > TargetRemote:
+		return Trg{}, fmt.Errorf("trigger target %d out of range", a.Target)
+	case a.Kind < 0 || a.Kind > 0x0F:
+		return Trg{}, fmt.Errorf("trigger action %d out of range", a.Kind)
+	case a.Options < 0 || a.Options > 0x03:
+		return Trg{}, fmt.Errorf("trigger options %d out of range", a.Options)
+	case a.Dwell < 0 || a.Dwell > 0x07:
+		return Trg{}, fmt.Errorf("trigger dwell %d out of range", a.Dwell)
+	case a.Channel < 0 || a.Channel > 0x1F:
+		return Trg{}, fmt.Errorf("trigger channel %d out of range", a.Channel)
+	case a.Node < 0 || a.Node > 0xFF || a.SetPoint < 0 || a.SetPoint > 0xFF:
+		return Trg{}, fmt.Errorf("trigger node %d / set point %d out of range", a.Node, a.SetPoint)
+	case a.UDPType < 0 || a.UDPType > 0xFF:
+		return Trg{}, fmt.Errorf("trigger UDP type %d out of range", a.UDPType)
+	case a.UDPSend != (a.UDPType != 0):
+		return Trg{}, fmt.Errorf("trigger UDP send %v doesn't match UDP type %d", a.UDPSend, a.UDPType)
+	case a.Target == TargetRemote && a.SetPoint != 0:
+		return Trg{}, fmt.Errorf("trigger set point %d can't go to a remote node, byte 3 is the node", a.SetPoint)
+	case a.Target != TargetRemote && a.Node != 0:
+		return Trg{}, fmt.Errorf("trigger node %d needs a remote target, byte 3 is the set point", a.Node)
+	}
+
+	t := Trg{
+		B1: int(a.Target)<<6 | a.Options<<4 | int(a.Kind),
+		B2: a.Dwell<<5 | a.Channel,
+		B3: a.SetPoint,
+		B4: a.UDPType,
+	}
+	if a.Target == TargetRemote {
+		t.B3 = a.Node
+	}
+
+	return t, nil
+}
+
+// String describes the action, e.g. "toggle DO 3" or "dwell DO 1 for CW 2, send UDP 165"
+func (a Action) String() string {
+
+	var desc string
+
+	switch {
+	case a.Kind == ActionNone:
+		desc = "none"
+	case a.Target == TargetRemote:
+		desc = fmt.Sprintf("%s node %d channel %d", a.Kind, a.Node, a.Channel)
+	case a.Target == TargetScene:
+		desc = fmt.Sprintf("%s scene %d", a.Kind, a.Channel)
+	default:
+		desc = fmt.Sprintf("%s %s %d", a.Kind, a.Target, a.Channel)
+	}
+
+	switch a.Kind {
+	case ActionDwell:
+		desc += fmt.Sprintf(" for CW %d", a.Dwell)
+	case ActionSetLevel:
+		desc += fmt.Sprintf(" to CS %d", a.SetPoint)
+	}
+
+	if a.UDPSend {
+		desc += fmt.Sprintf(", send UDP %d", a.UDPType)
+	}
+
+	return desc
+}
+
+// Action decodes the trigger
+func (t Trg) Action() Action {
+	return DecodeTrigger(t.B1, t.B2, t.B3, t.B4)
+}
+
+// Action decodes the low threshold trigger
+func (t TrgL) Action() Action {
+	return DecodeTrigger(t.B1, t.B2, t.B3, t.B4)
+}
+
+// Action decodes the high threshold trigger
+func (t TrgH) Action() Action {
+	return DecodeTrigger(t.B1, t.B2, t.B3, t.B4)
+}
+
+// String describes the trigger
+func (t Trg) String() string {
+	return t.Action().String()
+}
+
+// String describes the low threshold trigger
+func (t TrgL) String() string {
+	return fmt.Sprintf("below %d: %s", t.Lo, t.Action())
+}
+
+// String describes the high threshold trigger
+func (t TrgH) String() string {
+	return fmt.Sprintf("above %d: %s", t.Hi, t.Action())
+}
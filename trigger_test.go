@@ -0,0 +1,97 @@
+package webbrick
+
+import "testing"
+
+func TestDecodeTrigger(t *testing.T) {
+	tests := []struct {
+		trg  Trg
+		want Action
+		desc string
+	}{
+		{
+			Trg{0, 0, 0, 0},
+			Action{Target: TargetDigital, Kind: ActionNone},
+			"none",
+		},
+		{
+			Trg{4, 3, 0, 0},
+			Action{Target: TargetDigital, Kind: ActionToggle, Channel: 3},
+			"toggle DO 3",
+		},
+		{
+			Trg{5, 2<<5 | 1, 0, 165},
+			Action{Target: TargetDigital, Kind: ActionDwell, Channel: 1, Dwell: 2, UDPSend: true, UDPType: 165},
+			"dwell DO 1 for CW 2, send UDP 165",
+		},
+		{
+			Trg{1<<6 | 9, 2, 4, 0},
+			Action{Target: TargetAnalogue, Kind: ActionSetLevel, Channel: 2, SetPoint: 4},
+			"set level AO 2 to CS 4",
+		},
+		{
+			Trg{2<<6 | 7, 1, 0, 0},
+			Action{Target: TargetScene, Kind: ActionNext, Channel: 1},
+			"next scene 1",
+		},
+		{
+			Trg{3<<6 | 2, 5, 7, 0},
+			Action{Target: TargetRemote, Kind: ActionOn, Channel: 5, Node: 7},
+			"on node 7 channel 5",
+		},
+		{
+			Trg{3<<4 | 4, 31, 0, 0},
+			Action{Target: TargetDigital, Kind: ActionToggle, Channel: 31, Options: 3},
+			"toggle DO 31",
+		},
+	}
+
+	for _, tt := range tests {
+		got := tt.trg.Action()
+		if got != tt.want {
+			t.Errorf("%v: got %+v, want %+v", tt.trg, got, tt.want)
+		}
+		if got.String() != tt.desc {
+			t.Errorf("%v: described as %q, want %q", tt.trg, got.String(), tt.desc)
+		}
+
+		back, err := got.Encode()
+		if err != nil {
+			t.Errorf("%v: Encode error: %v", tt.trg, err)
+		} else if back != tt.trg {
+			t.Errorf("%v: Encode got %v", tt.trg, back)
+		}
+	}
+}
+
+func TestThresholdTriggers(t *testing.T) {
+	if got, want := (TrgL{Lo: 18, B1: 2, B2: 1}).String(), "below 18: on DO 1"; got != want {
+		t.Errorf("TrgL described as %q, want %q", got, want)
+	}
+	if got, want := (TrgH{Hi: 24, B1: 1}).String(), "above 24: off DO 0"; got != want {
+		t.Errorf("TrgH described as %q, want %q", got, want)
+	}
+}
+
+func TestEncodeTriggerErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		a    Action
+	}{
+		{"target", Action{Target: 4}},
+		{"action", Action{Kind: 16}},
+		{"options", Action{Options: 4}},
+		{"dwell", Action{Dwell: 8}},
+		{"channel", Action{Channel: 32}},
+		{"node", Action{Target: TargetRemote, Node: 256}},
+		{"set point", Action{SetPoint: -1}},
+		{"UDP type", Action{UDPType: 256, UDPSend: true}},
+		{"UDP send", Action{UDPSend: true}},
+		{"set point on a remote", Action{Target: TargetRemote, Node: 3, SetPoint: 50}},
+		{"node on a local", Action{Target: TargetAnalogue, Node: 3, SetPoint: 50}},
+	}
+	for _, tt := range tests {
+		if _, err := tt.a.Encode(); err == nil {
+			t.Errorf("%s: Encode(%+v) didn't fail", tt.name, tt.a)
+		}
+	}
+}
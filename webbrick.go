@@ -4,42 +4,55 @@ package webbrick
 
 import (
 	"bytes"
+	"context"                                   // For cancelling fetches
+	"encoding/xml"                              // For XML work
+	"errors"                                    // For crafting our own errors
+	"fmt"                                       // For outputting stuff
+	"github.com/davecgh/go-spew/spew"           // For neatly outputting stuff
+	"github.com/juju/loggo"                     //  logging
+	"github.com/paulcull/go-webbrick/packet"    // For decoding UDP packets
 	"github.com/paulrosania/go-charset/charset" // For XML conversion
 	_ "github.com/paulrosania/go-charset/data"  // Specs for dataset conversion
-	"encoding/xml"                         // For XML work
-	"errors"                               // For crafting our own errors
-	"fmt"                                  // For outputting stuff
-	"github.com/davecgh/go-spew/spew"      // For neatly outputting stuff
-	"github.com/juju/loggo"                //  logging
-	"io/ioutil"                            // HTTP body response processing
-	"net"                                  // For networking stuff - for UDP
-	"net/http"                             // For web http calls
-	"reflect"                              // Type Get
-	"strconv"                              // For String construction
-	"strings"                              // for Upper case conversion
-	"time"                                 // For Poller
+	"io/ioutil"                                 // HTTP body response processing
+	"math"                                      // For rounding levels
+	"net"                                       // For networking stuff - for UDP
+	"net/http"                                  // For web http calls
+	"strconv"                                   // For String construction
+	"strings"                                   // For matching type names
+	"time"                                      // For Poller
 )
 
 var myLog = loggo.GetLogger("Webbrick")
 
-// EventStruct is our equivalent to node.js's Emitters, of sorts.
-// This basically passes back to our Event channel, info about what event was raised
-// (e.g. Device, plus an event name) so we can act appropriately
+// WebbrickDriverConfig is the configuration a Client is built from.
+// Anything left at its zero value falls back to the package defaults
 type WebbrickDriverConfig struct {
 	Name string
 	//	NinjaLogControl *logger.Logger
-	Initialised     bool
-	NumberOfDevices int
-	PollingMinutes  int
-	PollingActive   bool
+	Initialised      bool
+	NumberOfDevices  int
+	PollingMinutes   int
+	PollingActive    bool
+	UDPPort          string          // UDP port to listen on, defaults to UDPPort
+	Debug            bool            // Dump out packets and devices as we go
+	Pirs             map[string]bool // Trigger inputs that are PIRs rather than buttons, defaults to PIRS
+	Exclude          map[string]bool // Devices that aren't in use, defaults to EXCLUDE
+	UDPCommandBricks []int           // Bricks to send commands to over UDP rather than HTTP
+	Gateway          *Gateway        // Reach the bricks through a gateway proxy rather than directly
 }
 
 // EventStruct is our equivalent to node.js's Emitters, of sorts.
 // This basically passes back to our Event channel, info about what event was raised
 // (e.g. Device, plus an event name) so we can act appropriately
 type EventStruct struct {
-	Name       string
-	DeviceInfo Device
+	Name       string         // The original event name, e.g. "newwebbrickfound". Kind is better for new code
+	DeviceInfo Device         // The device after the event
+	Kind       EventKind      // What happened
+	Previous   DeviceValue    // State and level before the event, empty for new devices
+	Current    DeviceValue    // State and level after the event
+	Time       time.Time      // When it happened
+	Source     EventSource    // Where it came from
+	Packet     *packet.Packet // The packet behind it, nil unless it came over UDP
 }
 
 // Device is info about the type of device that's been detected (socket, allone etc.)
@@ -54,32 +67,10 @@ type Device struct {
 	Subscribed  bool    // Have we subscribed to this item yet? Doing so lets us control
 	Queried     bool    // Have we queried this item for it's name and details yet?
 	State       bool    // Is the item turned on or off? Will always be "false" for the AllOne, which doesn't do states, just IR & 433
-	Level       float64 // What is the level of the device
+	Level       float64 // What is the level of the device. 0 to 100 for lights, however it was set, degrees C for temperatures
 	LastMessage string  // The last message to come through for this device
 }
 
-//////////////////////////////////
-//
-// Structure for UDP Message
-//
-//////////////////////////////////
-
-// Webbrick message format
-type WebBrickMsg struct {
-	Addr          string
-	PacketType    string
-	PacketSource  string
-	SourceChannel int
-	TargetChannel int
-	FromNodeNo    int
-	ProcessMsg    bool
-	Hour          string
-	Minute        string
-	Second        string
-	Day           string
-	Value         string
-}
-
 //////////////////////////////////
 //
 // Structure for WbStatus
@@ -135,11 +126,19 @@ type WebbrickConfig struct {
 	Version string `xml:"Ver,attr"`
 	Name    string `xml:"NN"`
 	IP      IPMac  `xml:"SI"`
+	BrickNo int    `xml:"SN"`
+	SRs     struct{ SR []SR }
+	SF      int
 	CDs     struct{ CD []CD }
+	CCs     struct{ CC []CC }
+	CWs     struct{ CW []CW }
+	CSs     struct{ CS []CS }
 	CTs     struct{ CT []CT }
 	CIs     struct{ CI []CI }
+	CEs     struct{ CE []CE }
 	NOs     struct{ NO []NO }
 	NAs     struct{ NA []NA }
+	MM      MM
 }
 
 type IPMac struct {
@@ -147,6 +146,12 @@ type IPMac struct {
 	MACString string `xml:"mac,attr"`
 }
 
+// SR is one of the brick's SR settings
+type SR struct {
+	Id    int `xml:"id,attr"`
+	Value int `xml:"Value,attr"`
+}
+
 // Device Configs
 type CD struct {
 	Id   int    `xml:"id,attr"`
@@ -179,6 +184,72 @@ type NA struct {
 	Name string `xml:"Name,attr"`
 }
 
+// CC is a scene. The masks pick which outputs the scene touches and the
+// state/values are what they get set to
+type CC struct {
+	Id           int `xml:"id,attr"`
+	DigitalMask  int `xml:"Dm,attr"` // Bit per digital output
+	DigitalState int `xml:"Ds,attr"` // Bit per digital output, on or off
+	AnalogueMask int `xml:"Am,attr"` // Bit per analogue output
+	AnalogueSet  int `xml:"Av,attr"` // Nibble per analogue output, picking a preset level (CS)
+}
+
+// SetPoint is the preset level (CS) the scene sets an analogue output to
+func (cc CC) SetPoint(channel int) int {
+	return (cc.AnalogueSet >> (4 * uint(channel))) & 0x0F
+}
+
+// CW is a dwell time, in seconds. Triggers pick one by its Id
+type CW struct {
+	Id    int `xml:"id,attr"`
+	Value int `xml:",chardata"`
+}
+
+// Duration is the dwell time as a Duration
+func (cw CW) Duration() time.Duration {
+	return time.Duration(cw.Value) * time.Second
+}
+
+// CS is a preset level (set point), 0-100. Triggers pick one by its Id
+type CS struct {
+	Id    int `xml:"id,attr"`
+	Value int `xml:",chardata"`
+}
+
+// CE is a scheduled event. It fires its trigger at Hours:Mins on the days in Days
+type CE struct {
+	Id    int `xml:"id,attr"`
+	Days  int `xml:"Days,attr"` // Bit per day, Sunday is bit 0
+	Hours int `xml:"Hours,attr"`
+	Mins  int `xml:"Mins,attr"`
+	Trg   Trg
+}
+
+// RunsOn is true if the event fires on that day
+func (ce CE) RunsOn(day time.Weekday) bool {
+	return ce.Days&(1<<uint(day)) != 0
+}
+
+// Weekdays are the days the event fires on
+func (ce CE) Weekdays() []time.Weekday {
+	var days []time.Weekday
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if ce.RunsOn(day) {
+			days = append(days, day)
+		}
+	}
+	return days
+}
+
+// MM is the mimic settings, for the LEDs that mirror the outputs
+type MM struct {
+	Lo       int `xml:"lo,attr"`  // Mimic level when off
+	Hi       int `xml:"hi,attr"`  // Mimic level when on
+	Digital  int `xml:"dig,attr"` // Bit per digital mimic
+	Analogue int `xml:"an,attr"`  // Bit per analogue mimic
+	Fade     int `xml:"fr,attr"`  // Fade rate
+}
+
 // Trigger Types
 type Trg struct {
 	B1 int `xml:"B1,attr"`
@@ -221,6 +292,42 @@ const (
 
 )
 
+var deviceTypeNames = map[int]string{
+	UNKNOWN:   "unknown",
+	LIGHT:     "light",
+	PIR:       "pir",
+	BUTTON:    "button",
+	TEMP:      "temp",
+	STATE:     "state",
+	HEARTBEAT: "heartbeat",
+}
+
+// DeviceTypeName is the lower case name of a device type, e.g. "light" for LIGHT
+func DeviceTypeName(devType int) string {
+	if name, ok := deviceTypeNames[devType]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// ParseDeviceType takes a type name ("light", "PIR") or number ("0") back to the type
+func ParseDeviceType(s string) (int, error) {
+
+	if n, err := strconv.Atoi(s); err == nil {
+		if _, ok := deviceTypeNames[n]; ok && n != UNKNOWN {
+			return n, nil
+		}
+		return UNKNOWN, fmt.Errorf("unknown device type %d", n)
+	}
+
+	for devType, name := range deviceTypeNames {
+		if devType != UNKNOWN && strings.EqualFold(name, s) {
+			return devType, nil
+		}
+	}
+	return UNKNOWN, fmt.Errorf("unknown device type %q", s)
+}
+
 // Setup some lists for a set of PIR's
 var PIRS = map[string]bool{
 	"2::TD::0":  true,
@@ -255,132 +362,117 @@ var EXCLUDE = map[string]bool{
 //
 //////////////////////////////////
 
-var conn *net.UDPConn // UDP Connection
 var DEBUG = false
 var POLL = false
 var PollingMinutes int
 
-const PollingTime = 600
-
-var Events = make(chan EventStruct, 50) // Events is our events channel which will notify calling code that we have an event happening
-var Devices = make(map[string]*Device)  // All the Devices we've discovered
-var deviceCount int                     // How many items we've discovered
+const PollingTime = 600 // Poll interval in seconds, when the config doesn't set PollingMinutes
 
 var UDPPort = "2552" // UDP Port
 
-var gwURL = "home.pkhome.co.uk" // Gateway
-var gwPORT = "8080"             // Gateway
-
-// Our UDP connection
-
 // ===============
 // Exported Events
 // ===============
 
-// Prepare is the first function you should call. Gets our UDP connection ready
-func Prepare(wbdc *WebbrickDriverConfig) (bool, error) {
-
-	if wbdc != nil {
-		wbdc =
-			&WebbrickDriverConfig{
-				Name:        "PKHome-TEST",
-				Initialised: false,
-				//				NinjaLogControl: logger.GetLogger("WebBrick Local"),
-				NumberOfDevices: 0,
-				PollingMinutes:  5,
-				PollingActive:   false,
-			}
-	}
-
-	//POLL = wbdc.PollingActive
-	//PollingMinutes = wbdc.PollingMinutes
+// Prepare is the first method you should call. Gets our UDP connection ready
+func (c *Client) Prepare() (bool, error) {
 
 	//	myLog = wbdc.NinjaLogControl
 	//myLog = loggo.GetLogger("WebBrick Local")
 
-	_, err := getLocalIP() // Get our local IP. Not actually used in this func, but is more of a failsafe
-	if err != nil {        // Error? Return false
+	ip, err := getLocalIP() // Get our local IP so we can ignore our own messages
+	if err != nil {         // Error? Return false
 		return false, err
 	}
+	c.localIP = ip
 
-	udpAddr, resolveErr := net.ResolveUDPAddr("udp4", ":"+UDPPort) // Get our address ready for listening
+	udpAddr, resolveErr := net.ResolveUDPAddr("udp4", ":"+c.UDPPort) // Get our address ready for listening
 	if resolveErr != nil {
 		return false, resolveErr
 	}
 
-	var listenErr error
-	conn, listenErr = net.ListenUDP("udp", udpAddr) // Now we listen on the address we just resolved
+	conn, listenErr := net.ListenUDP("udp", udpAddr) // Now we listen on the address we just resolved
 	if listenErr != nil {
 		return false, listenErr
 	}
+	c.mu.Lock()
+	c.conn = conn
+	c.mu.Unlock()
 
+	c.startHealth() // Whether messages come in through Run or CheckForMessages
+
+	c.Config.Initialised = true
 	return true, nil
 }
 
 // ListDevices spews out info about all the Devices we know about. It's great because it includes counts and other stuff
-func ListDevices() {
-	spew.Dump(&Devices)
+func (c *Client) ListDevices() {
+	spew.Dump(c.Devices.Snapshot())
 }
 
-// CheckForMessages does what it says on the tin -- checks for incoming UDP messages
-func CheckForMessages() (bool, error) { // Now we're checking for messages
-
-	var msg []byte
-	var buf [16]byte // We want to get 16 bytes of messages (is this enough? Need to check!)
+// CheckForMessages does what it says on the tin -- checks for incoming UDP messages.
+// It blocks until a message arrives, so most callers will want Run instead
+func (c *Client) CheckForMessages() (bool, error) { // Now we're checking for messages
 
-	var success bool
-	var err error
+	msg, addr, err := readMessage(c.udpConn(), c.localIP)
+	if err != nil {
+		return false, err
+	}
+	if msg == nil { // It was from us
+		return false, nil
+	}
 
-	n, addr, _ := conn.ReadFromUDP(buf[0:]) // Read 16 bytes from the buffer
-	ip, _ := getLocalIP()                   // Get our local IP
-	if n > 0 && addr.IP.String() != ip {    // If we've got more than 0 bytes and it's not from us
+	return c.handleMessage(msg, addr) // Hand it off to our handleMessage func. We pass on the message and the address (for replying to messages)
+}
 
-		msg = buf[0:n]                          // n is how many bytes we grabbed from UDP
-		success, err = handleMessage(msg, addr) // Hand it off to our handleMessage func. We pass on the message and the address (for replying to messages)
-		msg = nil                               // Clear out our msg property so we don't run handleMessage on old data
+// readMessage reads one datagram off the UDP connection. Messages from localIP, our own, come back as nil
+func readMessage(conn *net.UDPConn, localIP string) ([]byte, *net.UDPAddr, error) {
 
-	} else {
+	if conn == nil {
+		return nil, nil, ErrNotPrepared
+	}
 
-		myLog.Infof("From Us:", msg)
-		msg = nil
+	var buf [16]byte // Packets are 13 or 14 bytes, anything longer gets rejected by the decoder
 
+	n, addr, err := conn.ReadFromUDP(buf[0:]) // Read up to 16 bytes from the buffer
+	if err != nil {
+		return nil, nil, err
+	}
+	if n == 0 || addr.IP.String() == localIP { // Nothing there, or it's from us
+		myLog.Debugf("From Us: %v", buf[0:n])
+		return nil, addr, nil
 	}
 
-	return success, err
+	return buf[0:n], addr, nil // n is how many bytes we grabbed from UDP
 }
 
-// Poller for getting Status on WB's in one go
-func PollWBStatus(devID string) (int, error) {
-
-	// Run straight away
-	GetWBStatus(devID)
-	// then run based on the interval
+// PollWBStatus fetches a brick's status and config. With polling on it hands over to a
+// poller goroutine and returns straight away, otherwise it fetches once and returns, which
+// can take as long as the HTTP timeout. Don't call it from an event loop without a goroutine
+func (c *Client) PollWBStatus(devID string) (int, error) {
 
-	if POLL {
-		//myLog.Debugf("*************************** %s **************************", reflect.TypeOf(PollingTime))
-		//		for _ = range time.Tick(PollingMinutes * time.Minute) {
-		for _ = range time.Tick(PollingTime * time.Second) {
-			myLog.Infof("   **** Polling WBStatus & Config for ", devID)
-			GetWBStatus(devID)
+	// Keep polling on its own goroutine, so the caller isn't held up
+	if c.Poll {
+		if err := c.StartPolling(devID); err != nil {
+			return 0, err
 		}
+		return 1, nil
 	}
-	return 1, nil
+
+	// Otherwise just run the once
+	return c.GetWBStatus(devID)
 
 }
 
 // Get WB Status on Initilisation
-func GetWBStatus(devID string) (int, error) {
+func (c *Client) GetWBStatus(devID string) (int, error) {
 
 	myLog.Infof("   **** Getting WBStatus & Config for ", devID)
 
-	var success int
-	var statusCommand string
-	var configCommand string
-	// will need to use the gateway if the call is outside the local network
-	// statusCommand = "http://" + gwURL + ":" + gwPORT + "/wbproxy/" + Devices[devID].IP.String() + "/WbStatus.xml"
-	// configCommand = "http://" + gwURL + ":" + gwPORT + "/wbproxy/" + Devices[devID].IP.String() + "/WbCfg.xml"
-	statusCommand = "http://" + Devices[devID].IP.String() + "/WbStatus.xml"
-	configCommand = "http://" + Devices[devID].IP.String() + "/WbCfg.xml"
+	brick, err := c.Devices.Get(devID)
+	if err != nil {
+		return 0, err
+	}
 
 	///////////////////////////////
 	//
@@ -388,97 +480,105 @@ func GetWBStatus(devID string) (int, error) {
 	//
 	////////////////////////////////
 
-	// http call for the wb status
-	resp, err := http.Get(statusCommand) // call the http service
+	_wbs, err := c.FetchStatus(brick.IP)
 	if err != nil {
-		myLog.Errorf("Error getting WBStatus for " + Devices[devID].IP.String())
+		myLog.Errorf("Error getting WBStatus for %s: %v", brick.IP, err)
+		c.pollFailed(brick.BrickID, err)
 		return 0, err
 	}
-	defer resp.Body.Close()
+	myLog.Infof("      **** Got WebbrickStatus ok for ", devID)
 
-	respbody, err := ioutil.ReadAll(resp.Body) // read out the reponsse body
-	if err != nil {
-		myLog.Errorf("Empty Body in http request for " + Devices[devID].IP.String())
-		return 0, err
-	} else {
-		success = 1
-	}
-	//myLog.Debugf("%s \n", respbody)
-
-	// Decode WB Status XML encoding
-	var _wbs WebbrickStatus                   // create container to load the xml
-	reader := bytes.NewReader(respbody)       // create a new reader for transcoding to utf-8
-	decoder := xml.NewDecoder(reader)         // create a new xml decoder
-	decoder.CharsetReader = charset.NewReader // bind the reader to the decoder
-	xmlerr := decoder.Decode(&_wbs)           // unmarshall the xml
-	if xmlerr != nil {
-		myLog.Errorf("error: %v", xmlerr)
-		return 0, xmlerr
-	} else {
-		myLog.Infof("      **** Got WebbrickStatus ok for ", devID)
-
-	}
-
-	if DEBUG {
+	if c.Debug {
 		myLog.Debugf(spew.Sdump(_wbs))
 	}
+
 	///////////////////////////////
 	//
 	// WB Config
 	//
 	////////////////////////////////
 
-	// http call for the wb config
-	wbcresp, wbcerr := http.Get(configCommand) // call the http service
-	if wbcerr != nil {
-		myLog.Errorf("Error getting WBConfig for " + Devices[devID].IP.String())
-	}
-	defer wbcresp.Body.Close()
-
-	wbcrespbody, wbcerr := ioutil.ReadAll(wbcresp.Body) // read out the reponsse body
-	if wbcerr != nil {
-		myLog.Errorf("Empty Body in http request for " + Devices[devID].IP.String())
-		success = 0
-		err = wbcerr
-		return success, wbcerr
-	} else {
-		success = 1
-	}
-	//myLog.Debugf("%s \n", wbcrespbody)
-
-	// Decode WB Config XML encoding
-	var _wbc WebbrickConfig                      // create container to load the xml
-	wbcreader := bytes.NewReader(wbcrespbody)    // create a new reader for transcoding to utf-8
-	wbcdecoder := xml.NewDecoder(wbcreader)      // create a new xml decoder
-	wbcdecoder.CharsetReader = charset.NewReader // bind the reader to the decoder
-	wbcxmlerr := wbcdecoder.Decode(&_wbc)        // unmarshall the xml
-	if wbcxmlerr != nil {
-		myLog.Errorf("error: %v", wbcxmlerr)
-		return 0, wbcxmlerr
-	} else {
-		myLog.Infof("      **** Got WebbrickConfig ok for ", _wbc.Name)
-		success = 1
-		//err = wbcxmlerr
+	_wbc, err := c.FetchConfig(brick.IP)
+	if err != nil {
+		myLog.Errorf("Error getting WBConfig for %s: %v", brick.IP, err)
+		c.pollFailed(brick.BrickID, err)
+		return 0, err
 	}
+	myLog.Infof("      **** Got WebbrickConfig ok for ", _wbc.Name)
 
-	if DEBUG {
+	if c.Debug {
 		myLog.Debugf(spew.Sdump(_wbc))
 	}
 
-	mapDevices, mderr := CreateBrickDevices(_wbc, _wbs)
+	c.storeBrick(_wbc, _wbs)
+	c.pollSucceeded(brick.BrickID)
 
+	_, mderr := c.CreateBrickDevices(_wbc, _wbs)
 	if mderr != nil {
 		myLog.Errorf("error mapping devices %v", mderr)
-		success = mapDevices
-		err = mderr
 		return 0, mderr
 	}
 
-	if DEBUG {
-		ListDevices()
+	if c.Debug {
+		c.ListDevices()
 	}
 
-	return success, err
+	return 1, nil
+}
+
+// FetchStatus gets WbStatus.xml from the brick at ip
+func (c *Client) FetchStatus(ip net.IP) (WebbrickStatus, error) {
+	return c.FetchStatusContext(context.Background(), ip)
+}
+
+// FetchStatusContext gets WbStatus.xml from the brick at ip, giving up when ctx is done
+func (c *Client) FetchStatusContext(ctx context.Context, ip net.IP) (WebbrickStatus, error) {
+	var _wbs WebbrickStatus
+	err := c.fetchXML(ctx, ip, "/WbStatus.xml", &_wbs)
+	return _wbs, err
+}
+
+// FetchConfig gets WbCfg.xml from the brick at ip
+func (c *Client) FetchConfig(ip net.IP) (WebbrickConfig, error) {
+	return c.FetchConfigContext(context.Background(), ip)
+}
+
+// FetchConfigContext gets WbCfg.xml from the brick at ip, giving up when ctx is done
+func (c *Client) FetchConfigContext(ctx context.Context, ip net.IP) (WebbrickConfig, error) {
+	var _wbc WebbrickConfig
+	err := c.fetchXML(ctx, ip, "/WbCfg.xml", &_wbc)
+	return _wbc, err
+}
+
+// fetchXML gets an XML page off a brick, through the endpoint, and decodes it into v
+func (c *Client) fetchXML(ctx context.Context, ip net.IP, page string, v interface{}) error {
+
+	req, err := c.endpoint().NewRequest(ctx, ip, page)
+	if err != nil {
+		return err
+	}
+
+	// http call for the page
+	resp, err := c.httpClient.Do(req) // call the http service
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("getting %s: %s", req.URL, resp.Status)
+	}
+
+	respbody, err := ioutil.ReadAll(resp.Body) // read out the reponsse body
+	if err != nil {
+		return err
+	}
+
+	// Decode the XML, the bricks send ISO-8859-1
+	reader := bytes.NewReader(respbody)       // create a new reader for transcoding to utf-8
+	decoder := xml.NewDecoder(reader)         // create a new xml decoder
+	decoder.CharsetReader = charset.NewReader // bind the reader to the decoder
+	return decoder.Decode(v)                  // unmarshall the xml
 }
 
 ///////////////////////////////////////////
@@ -487,7 +587,7 @@ func GetWBStatus(devID string) (int, error) {
 //
 ///////////////////////////////////////////
 
-func CreateBrickDevices(_wbc WebbrickConfig, _wbs WebbrickStatus) (int, error) {
+func (c *Client) CreateBrickDevices(_wbc WebbrickConfig, _wbs WebbrickStatus) (int, error) {
 
 	var success int
 	var err error
@@ -498,6 +598,10 @@ func CreateBrickDevices(_wbc WebbrickConfig, _wbs WebbrickStatus) (int, error) {
 
 	_ip = net.ParseIP(_wbc.IP.IPString)
 
+	raise := func(message string, kind EventKind, prev Device, dev Device) {
+		c.passMessage(message, kind, SourcePoll, prev, dev, nil)
+	}
+
 	myLog.Infof("      **** Checking Devices for ", _wbc.Name)
 
 	// Lights - AO
@@ -508,7 +612,7 @@ func CreateBrickDevices(_wbc WebbrickConfig, _wbs WebbrickStatus) (int, error) {
 
 		// // Calculate the UID
 		UID := strconv.Itoa(_wbs.BrickNo) + "::AO::" + strconv.Itoa(light)
-		if !EXCLUDE[UID] {
+		if !c.Exclude[UID] {
 			if _wbs.AOs.AO[light].Value == 0 {
 				_state = false
 				_message = _wbc.NAs.NA[light].Name + " is off"
@@ -518,19 +622,20 @@ func CreateBrickDevices(_wbc WebbrickConfig, _wbs WebbrickStatus) (int, error) {
 			}
 
 			// Check to see if we've already got macAdd in our array
-			_, ok := Devices[UID]
+			prev, unknown := c.Devices.Get(UID)
 
-			if ok == false { // we haven't got this in our Devices array
-				deviceCount++
-				Devices[UID] = &Device{deviceCount, UID, _wbc.NAs.NA[light].Name, _wbs.BrickNo, LIGHT, _wbs.AOs.AO[light].Id, _ip, true, true, _state, _wbs.AOs.AO[light].Value, _message}
-				passMessage("newlightchannelfound", *Devices[UID])
+			if unknown != nil { // we haven't got this in our Devices array
+				dev, _ := c.Devices.add(Device{0, UID, _wbc.NAs.NA[light].Name, _wbs.BrickNo, LIGHT, _wbs.AOs.AO[light].Id, _ip, true, true, _state, _wbs.AOs.AO[light].Value, _message})
+				raise("newlightchannelfound", EventDiscovered, prev, dev)
 				myLog.Infof("        **** Creating Light Device for ", UID, _wbs.AOs.AO[light], _wbc.NAs.NA[light])
 			} else {
-				Devices[UID].State = _state
-				Devices[UID].Name = _wbc.NAs.NA[light].Name
-				Devices[UID].Level = _wbs.AOs.AO[light].Value
-				Devices[UID].LastMessage = _message
-				passMessage("existinglightchannelupdated", *Devices[UID])
+				dev, _ := c.Devices.update(UID, func(d *Device) {
+					d.State = _state
+					d.Name = _wbc.NAs.NA[light].Name
+					d.Level = _wbs.AOs.AO[light].Value
+					d.LastMessage = _message
+				})
+				raise("existinglightchannelupdated", EventUpdated, prev, dev)
 				myLog.Infof("        **** Updating Light Device for ", UID, _wbs.AOs.AO[light], _wbc.NAs.NA[light])
 			}
 		} else {
@@ -547,37 +652,39 @@ func CreateBrickDevices(_wbc WebbrickConfig, _wbs WebbrickStatus) (int, error) {
 		UID := strconv.Itoa(_wbs.BrickNo) + "::TD::" + strconv.Itoa(digitalIn)
 
 		// TODO : remove PIR exclusion and fix the channel that is should create
-		if !EXCLUDE[UID] { //&& !PIRS[UID] {
+		if !c.Exclude[UID] { //&& !c.Pirs[UID] {
 
 			// Check to see if we've already got macAdd in our array
-			_, ok := Devices[UID]
+			prev, unknown := c.Devices.Get(UID)
 
-			if ok == false { // we haven't got this in our Devices array
-				if !PIRS[UID] { // handle PIR from list, as you can't tell the difference normally
+			if unknown != nil { // we haven't got this in our Devices array
+				if !c.Pirs[UID] { // handle PIR from list, as you can't tell the difference normally
 					_message = _wbc.CDs.CD[digitalIn].Name + " has been found"
-					deviceCount++
-					Devices[UID] = &Device{deviceCount, UID, _wbc.CDs.CD[digitalIn].Name, _wbs.BrickNo, BUTTON, digitalIn, _ip, true, true, false, 0, _message}
-					passMessage("newbuttonfound", *Devices[UID])
+					dev, _ := c.Devices.add(Device{0, UID, _wbc.CDs.CD[digitalIn].Name, _wbs.BrickNo, BUTTON, digitalIn, _ip, true, true, false, 0, _message})
+					raise("newbuttonfound", EventDiscovered, prev, dev)
 					myLog.Infof("        **** Creating Button Device for ", UID, _wbc.CDs.CD[digitalIn])
 				} else {
 					_message = _wbc.CDs.CD[digitalIn].Name + " has been found"
-					deviceCount++
-					Devices[UID] = &Device{deviceCount, UID, _wbc.CDs.CD[digitalIn].Name, _wbs.BrickNo, PIR, digitalIn, _ip, true, true, false, 0, _message}
-					passMessage("newpirfound", *Devices[UID])
+					dev, _ := c.Devices.add(Device{0, UID, _wbc.CDs.CD[digitalIn].Name, _wbs.BrickNo, PIR, digitalIn, _ip, true, true, false, 0, _message})
+					raise("newpirfound", EventDiscovered, prev, dev)
 					myLog.Infof("        **** Creating PIR Device for ", UID, _wbc.CDs.CD[digitalIn])
 				}
 			} else {
-				if !PIRS[UID] {
+				if !c.Pirs[UID] {
 					_message = _wbc.CDs.CD[digitalIn].Name + " has been pressed"
-					Devices[UID].LastMessage = _message
-					Devices[UID].Name = _wbc.CDs.CD[digitalIn].Name
-					passMessage("existingbuttonupdated", *Devices[UID])
+					dev, _ := c.Devices.update(UID, func(d *Device) {
+						d.LastMessage = _message
+						d.Name = _wbc.CDs.CD[digitalIn].Name
+					})
+					raise("existingbuttonupdated", EventUpdated, prev, dev)
 					myLog.Infof("        **** Updating Button Device for ", UID, _wbc.CDs.CD[digitalIn])
 				} else {
 					_message = _wbc.CDs.CD[digitalIn].Name + " has been triggered"
-					Devices[UID].LastMessage = _message
-					Devices[UID].Name = _wbc.CDs.CD[digitalIn].Name
-					passMessage("existingpirupdated", *Devices[UID])
+					dev, _ := c.Devices.update(UID, func(d *Device) {
+						d.LastMessage = _message
+						d.Name = _wbc.CDs.CD[digitalIn].Name
+					})
+					raise("existingpirupdated", EventUpdated, prev, dev)
 					myLog.Infof("        **** Updating PIR Device for ", UID, _wbc.CDs.CD[digitalIn])
 				}
 			}
@@ -595,21 +702,22 @@ func CreateBrickDevices(_wbc WebbrickConfig, _wbs WebbrickStatus) (int, error) {
 		// // Calculate the UID
 		UID := strconv.Itoa(_wbs.BrickNo) + "::DO::" + strconv.Itoa(digitalOut)
 
-		if !EXCLUDE[UID] {
+		if !c.Exclude[UID] {
 			// Check to see if we've already got macAdd in our array
-			_, ok := Devices[UID]
+			prev, unknown := c.Devices.Get(UID)
 
-			if ok == false { // we haven't got this in our Devices array
-				deviceCount++
+			if unknown != nil { // we haven't got this in our Devices array
 				_message = _wbc.NOs.NO[digitalOut].Name + " state has been found"
-				Devices[UID] = &Device{deviceCount, UID, _wbc.NOs.NO[digitalOut].Name, _wbs.BrickNo, STATE, digitalOut, _ip, true, true, false, 0, _message}
-				passMessage("newoutputfound", *Devices[UID])
+				dev, _ := c.Devices.add(Device{0, UID, _wbc.NOs.NO[digitalOut].Name, _wbs.BrickNo, STATE, digitalOut, _ip, true, true, false, 0, _message})
+				raise("newoutputfound", EventDiscovered, prev, dev)
 				myLog.Infof("        **** Creating State Device for ", UID, _wbc.NOs.NO[digitalOut])
 			} else {
 				_message = _wbc.NOs.NO[digitalOut].Name + " state has changed"
-				Devices[UID].LastMessage = _message
-				Devices[UID].Name = _wbc.NOs.NO[digitalOut].Name
-				passMessage("existingoutputupdated", *Devices[UID])
+				dev, _ := c.Devices.update(UID, func(d *Device) {
+					d.LastMessage = _message
+					d.Name = _wbc.NOs.NO[digitalOut].Name
+				})
+				raise("existingoutputupdated", EventUpdated, prev, dev)
 				myLog.Infof("        **** Updating State Device for ", UID, _wbc.NOs.NO[digitalOut])
 			}
 		} else {
@@ -627,20 +735,21 @@ func CreateBrickDevices(_wbc WebbrickConfig, _wbs WebbrickStatus) (int, error) {
 		// Calculate the UID
 		UID := strconv.Itoa(_wbs.BrickNo) + "::CT::" + strconv.Itoa(temp)
 
-		if !EXCLUDE[UID] {
+		if !c.Exclude[UID] {
 			// Check to see if we've already got macAdd in our array
-			_, ok := Devices[UID]
+			prev, unknown := c.Devices.Get(UID)
 
-			if ok == false { // we haven't got this in our Devices array
-				deviceCount++
-				Devices[UID] = &Device{deviceCount, UID, _wbc.CTs.CT[temp].Name, _wbs.BrickNo, TEMP, temp, _ip, true, true, false, (_wbs.Tmps.Tmp[temp].Value / 16), _message}
-				passMessage("newtempfound", *Devices[UID])
+			if unknown != nil { // we haven't got this in our Devices array
+				dev, _ := c.Devices.add(Device{0, UID, _wbc.CTs.CT[temp].Name, _wbs.BrickNo, TEMP, temp, _ip, true, true, false, (_wbs.Tmps.Tmp[temp].Value / 16), _message})
+				raise("newtempfound", EventDiscovered, prev, dev)
 				myLog.Infof("        **** Creating Temperature Device for ", UID, _wbc.CTs.CT[temp])
 			} else {
-				Devices[UID].LastMessage = _message
-				Devices[UID].Name = _wbc.CTs.CT[temp].Name
-				Devices[UID].Level = (_wbs.Tmps.Tmp[temp].Value / 16)
-				passMessage("existingtempupdated", *Devices[UID])
+				dev, _ := c.Devices.update(UID, func(d *Device) {
+					d.LastMessage = _message
+					d.Name = _wbc.CTs.CT[temp].Name
+					d.Level = (_wbs.Tmps.Tmp[temp].Value / 16)
+				})
+				raise("existingtempupdated", EventUpdated, prev, dev)
 				myLog.Infof("        **** Updating Temperature Device for ", UID, _wbc.CTs.CT[temp])
 			}
 
@@ -661,122 +770,116 @@ func CreateBrickDevices(_wbc WebbrickConfig, _wbs WebbrickStatus) (int, error) {
 ///////////////////////////////////////////
 
 // ToggleState finds out if the socket is on or off, then toggles it
-func ToggleState(devID string) (bool, error) {
-	if Devices[devID].State == true {
-		return SetState(devID, false)
+func (c *Client) ToggleState(devID string) (bool, error) {
+	dev, err := c.Devices.Get(devID)
+	if err != nil {
+		return false, err
 	}
 
-	return SetState(devID, true)
-}
+	if dev.State == true {
+		return c.SetState(devID, false)
+	}
 
-func SetLightLevel(devID string, level float64) (bool, error) {
+	return c.SetState(devID, true)
+}
 
-	var command string
+// SetLightLevel sets the level of a light, 0 to 1. The device's Level ends up 0 to 100, like the bricks report it
+func (c *Client) SetLightLevel(devID string, level float64) (bool, error) {
 
 	// update the record for new levels
-	Devices[devID].Level = float64(level)
-	//var statebit string
-	if Devices[devID].Level == 0 {
-		Devices[devID].State = false
-	} else {
-		Devices[devID].State = true
+	var prev Device
+	dev, err := c.Devices.update(devID, func(d *Device) {
+		prev = *d
+		d.Level = float64(percent(level))
+		//var statebit string
+		if d.Level == 0 {
+			d.State = false
+		} else {
+			d.State = true
+		}
+	})
+	if err != nil {
+		return false, err
 	}
 
 	// create and send the command
+	myLog.Debugf("++++++++++++ in SetLevel for LIGHT on %s at %v ++++++++++++", devID, dev.Level)
+	start := time.Now()
+	err = c.transportFor(dev.BrickID).SetLevel(dev, int(dev.Level))
+	c.countCommand(start, err)
+	if err != nil {
+		myLog.Errorf("Error setting light level for %s: %v", devID, err)
+	}
 
-	command = "http://" + Devices[devID].IP.String() + "/hid.spi?com=%3A&com=AA" + strconv.Itoa(Devices[devID].Channel) + "%3B" + strconv.FormatFloat((Devices[devID].Level*100), 'f', 0, 64) + "&com=%3A"
-
-	// http://192.168.1.249/hid.spi?com=%3A&com=AA0%3B85&com=%3A
-
-	myLog.Debugf("++++++++++++ in SetLevel for LIGHT with %s ++++++++++++\n", command)
-	success, err := sendCommand(command, devID)
-
-	passMessage("lightset:"+strconv.FormatFloat(Devices[devID].Level, 'f', 6, 64), *Devices[devID])
-	command = ""
-	return success, err
+	c.passMessage("lightset:"+strconv.FormatFloat(dev.Level, 'f', 6, 64), EventCommanded, SourceCommand, prev, dev, nil)
+	return err == nil, err
 
 }
 
 // SetState sets the state of a device
-func SetState(devID string, state bool) (bool, error) {
+func (c *Client) SetState(devID string, state bool) (bool, error) {
 
-	var command, _wbstate string
 	var _level float64
-	var _success bool
-	var _err error
-
-	_err = nil
-	_success = true
 
 	// update the record for new levels
-	Devices[devID].State = state
+	var prev Device
+	dev, err := c.Devices.update(devID, func(d *Device) {
+		prev = *d
+		d.State = state
+	})
+	if err != nil {
+		return false, err
+	}
 
-	myLog.Debugf("++++++++++++ in SetState for State on %s with %s ++++++++++++\n", devID, command)
+	myLog.Debugf("++++++++++++ in SetState for State on %s with %v ++++++++++++", devID, state)
 
-	// Convert state to the webbrick, and override the level if it's a light
+	// Override the level if it's a light
 	if state {
-		_wbstate = "N" // On
-		if Devices[devID].Level == 0 {
-			_level = 0.95
+		if dev.Level == 0 {
+			_level = 95
 		} else {
-			_level = Devices[devID].Level
+			_level = dev.Level
 		}
 	} else {
-		_wbstate = "F" // Off
 		_level = 0
 	}
 
 	// if the state is on a light device then check and set the light level
-	if Devices[devID].Type == LIGHT {
-		// create and send the command
-		command = "http://" + Devices[devID].IP.String() + "/hid.spi?com=%3A&com=AA" + strconv.Itoa(Devices[devID].Channel) + "%3B" + strconv.FormatFloat((_level*100), 'f', 0, 64) + "&com=%3A"
-
-		myLog.Debugf("++++++++++++ in SetState for Level on %s with %s ++++++++++++\n", devID, command)
-		success, err := sendCommand(command, devID)
-		if err != nil {
-			myLog.Errorf("Error setting light level in state for ", command, "\n", err)
-			_err = err
-		} else {
-			_success = success
-		}
-		passMessage("stateset:"+strconv.FormatFloat(Devices[devID].Level, 'f', 6, 64), *Devices[devID])
+	transport := c.transportFor(dev.BrickID)
+	start := time.Now()
+	if dev.Type == LIGHT {
+		err = transport.SetLevel(dev, int(math.Round(_level)))
 	} else {
-
-		// create and send the command
-		command = "http://" + Devices[devID].IP.String() + "/hid.spi?com=%3A&com=DO" + strconv.Itoa(Devices[devID].Channel) + "%3B" + _wbstate + "&com=%3A"
-
-		myLog.Debugf("++++++++++++ in SetState for State on %s with %s ++++++++++++\n", devID, command)
-		success, err := sendCommand(command, devID)
-		if err != nil {
-			myLog.Errorf("Error setting light level in state for ", command)
-			_err = err
-		} else {
-			_success = success
-		}
-		passMessage("stateset:"+strconv.FormatFloat(Devices[devID].Level, 'f', 6, 64), *Devices[devID])
+		err = transport.SetOutput(dev, state)
+	}
+	c.countCommand(start, err)
+	if err != nil {
+		myLog.Errorf("Error setting state for %s: %v", devID, err)
 	}
 
-	command = ""
-	return _success, _err
+	c.passMessage("stateset:"+strconv.FormatFloat(dev.Level, 'f', 6, 64), EventCommanded, SourceCommand, prev, dev, nil)
+	return err == nil, err
 
 }
 
-// SetState sets the state of a socket, given its MAC address
-func PushButton(devID string) (bool, error) {
-
-	var command string
-
-	// create and send the command
-	command = "http://" + Devices[devID].IP.String() + "/hid.spi?com=%3A&com=DI" + strconv.Itoa(Devices[devID].Channel) + "&com=%3A"
+// PushButton triggers a digital input on the brick, as if the button was pressed
+func (c *Client) PushButton(devID string) (bool, error) {
 
-	myLog.Debugf("Push button ", command)
+	dev, err := c.Devices.Get(devID)
+	if err != nil {
+		return false, err
+	}
 
-	myLog.Debugf("++++++++++++ in PushButton and going to send %s ++++++++++++\n", command)
-	success, err := sendCommand(command, devID)
+	myLog.Debugf("++++++++++++ in PushButton for %s ++++++++++++", devID)
+	start := time.Now()
+	err = c.transportFor(dev.BrickID).TriggerInput(dev)
+	c.countCommand(start, err)
+	if err != nil {
+		myLog.Errorf("Error pushing button %s: %v", devID, err)
+	}
 
-	passMessage("button", *Devices[devID])
-	command = ""
-	return success, err
+	c.passMessage("button", EventCommanded, SourceCommand, dev, dev, nil)
+	return err == nil, err
 
 }
 
@@ -787,18 +890,21 @@ func PushButton(devID string) (bool, error) {
 ////////////////////////////////////////////////
 
 // GetState gets the state of a device, given its ID
-func GetState(devID string) bool {
-	return Devices[devID].State
+func (c *Client) GetState(devID string) (bool, error) {
+	dev, err := c.Devices.Get(devID)
+	return dev.State, err
 }
 
 // GetLevel gets the level of a device, given its ID
-func GetLevel(devID string) float64 {
-	return Devices[devID].Level
+func (c *Client) GetLevel(devID string) (float64, error) {
+	dev, err := c.Devices.Get(devID)
+	return dev.Level, err
 }
 
-// GetLevel gets the level of a device, given its ID
-func GetLastMessage(devID string) string {
-	return Devices[devID].LastMessage
+// GetLastMessage gets the last message for a device, given its ID
+func (c *Client) GetLastMessage(devID string) (string, error) {
+	dev, err := c.Devices.Get(devID)
+	return dev.LastMessage, err
 }
 
 // ==================
@@ -806,223 +912,134 @@ func GetLastMessage(devID string) string {
 // ==================
 
 // handleMessage parses a message found by CheckForMessages
-func handleMessage(buf []byte, addr *net.UDPAddr) (bool, error) {
-
-	var _tmpValue = 0
+func (c *Client) handleMessage(buf []byte, addr *net.UDPAddr) (bool, error) {
 
 	//Strip out the information sent from the brick
-	resp := new(WebBrickMsg)
-	resp.Addr = addr.IP.String()
-	//resp.PacketSource = strings.ToUpper(resp.PacketSource)
-
-	for index, element := range buf {
-		switch index {
-		case 1:
-			resp.PacketType = string(element)
-		case 2:
-			resp.PacketSource = string(element)
-		case 3:
-			resp.PacketSource = resp.PacketSource + string(element)
-		case 4:
-			switch resp.PacketSource {
-			case "ST": // Handle time message differently
-				resp.Hour = strconv.Itoa(int(element))
-			default:
-				resp.SourceChannel = int(element)
-			}
-		case 5:
-			switch resp.PacketSource {
-			case "ST": // Handle time message differently
-				resp.Minute = strconv.Itoa(int(element))
-			default:
-				resp.TargetChannel = int(element)
-			}
-		case 6:
-			switch resp.PacketSource {
-			case "ST": // Handle time message differently
-				resp.Second = strconv.Itoa(int(element) / 2)
-			default:
-			}
-		case 7:
-			resp.FromNodeNo = int(element)
-		case 9:
-			switch resp.PacketSource {
-			case "ST": // Handle time message differently
-				resp.Day = strconv.Itoa(int(element))
-			default:
-			}
-		case 11:
-			switch strings.ToUpper(resp.PacketSource) {
-			case "AO", "AI", "CT":
-				resp.Value = strconv.Itoa(int(element))
-				_tmpValue = int(element)
-			default:
-			}
-		case 12:
-			switch strings.ToUpper(resp.PacketSource) {
-			case "CT":
-				resp.Value = strconv.Itoa(int(element) + _tmpValue)
-			default:
-			}
-		default:
-
+	pkt, err := packet.Decode(buf)
+	if err != nil {
+		c.countBadPacket(errors.Is(err, packet.ErrUnknownType))
+		if errors.Is(err, packet.ErrUnknownType) {
+			myLog.Errorf("Unknown Device type found : %s from %s", pkt.Type, addr.IP)
 		}
-
-		// index is the index where we are
-		// element is the element from someSlice for where we are
-
-		_checkSource := strings.ToUpper(resp.PacketSource)
-
-		if DEBUG {
-			fmt.Println(" Handler for " + resp.PacketSource + "(" + _checkSource + ")")
-			fmt.Println(index)
-			fmt.Printf(" : ")
-			fmt.Println((reflect.TypeOf(element)))
-			fmt.Printf(" : ")
-			fmt.Println((element))
-			fmt.Printf(" : ")
-			fmt.Println(string(element))
-			fmt.Printf("\n")
-		}
-
-		if index > 3 && _checkSource != "ST" && _checkSource != "CT" && _checkSource != "AO" && _checkSource != "DO" && _checkSource != "TD" {
-			myLog.Errorf("Unknown Device type found : ", resp.PacketSource, _checkSource)
-
-			if DEBUG {
-				fmt.Println("Missing Handler for " + resp.PacketSource + "(" + _checkSource + ")")
-				fmt.Println(index)
-				fmt.Printf(" : ")
-				fmt.Println((reflect.TypeOf(element)))
-				fmt.Printf(" : ")
-				fmt.Println((element))
-				fmt.Printf(" : ")
-				fmt.Println(string(element))
-				fmt.Printf("\n")
-			}
+		if c.Debug {
+			fmt.Printf("Bad packet from %s : %v : % x\n", addr.IP, err, buf)
 		}
+		return false, err
 	}
 
-	if DEBUG {
-		fmt.Printf("\n :::: ")
-		fmt.Printf(resp.Addr)
-		fmt.Printf(" :::: ")
-		fmt.Printf(strconv.Itoa(resp.FromNodeNo))
-		fmt.Printf(" ::--:: ")
-		fmt.Printf(resp.PacketSource)
-		fmt.Printf(" :::: ")
-		fmt.Printf(strconv.Itoa(resp.SourceChannel))
-		fmt.Printf(" :::: ")
-		fmt.Printf(resp.Value)
-		fmt.Printf(" ::--:: ")
-		fmt.Printf(resp.Hour)
-		fmt.Printf(" :::: ")
-		fmt.Printf(resp.Minute)
-		fmt.Printf(" :::: ")
-		fmt.Printf(resp.Second)
-		fmt.Printf(" :::: ")
-		fmt.Printf(resp.Day)
-		fmt.Printf(" :::: \n")
-		fmt.Print(resp)
-		fmt.Print("\n")
+	if c.Debug {
+		fmt.Printf("\n :::: %s :::: %s\n", addr.IP, spew.Sdump(pkt))
 	}
 
-	UID := strconv.Itoa(resp.FromNodeNo) + "::" + strings.ToUpper(resp.PacketSource) + "::" + strconv.Itoa(resp.SourceChannel)
+	c.countPacket(pkt.Type)
+	UID := pkt.UID()
 
 	myLog.Infof(UID + " seen ")
+	c.noteSender(addr.IP)
+
+	raise := func(message string, kind EventKind, prev Device, dev Device) {
+		c.passMessage(message, kind, SourceUDP, prev, dev, &pkt)
+	}
+
+	switch pkt.Type {
+	case packet.TypeTime: // Timestamp
 
-	switch strings.ToUpper(resp.PacketSource) {
-	case "ST": // Timestamp
+		_message := fmt.Sprintf("Seen at %d:%d:%d", pkt.Time.Hour, pkt.Time.Minute, pkt.Time.Second)
 
-		_message := "Seen at " + resp.Hour + ":" + resp.Minute + ":" + resp.Second
+		c.heartbeat(pkt.FromNode)
 
 		// Check to see if we've already got macAdd in our array
-		_, ok := Devices[UID]
+		prev, unknown := c.Devices.Get(UID)
 
-		if ok == false { // we haven't got this in our Devices array
-			deviceCount++
-			Devices[UID] = &Device{deviceCount, UID, "", resp.FromNodeNo, HEARTBEAT, resp.SourceChannel, addr.IP, true, false, false, 0, _message}
-			passMessage("newwebbrickfound", *Devices[UID])
+		if unknown != nil { // we haven't got this in our Devices array
+			dev, _ := c.Devices.add(Device{0, UID, "", pkt.FromNode, HEARTBEAT, pkt.SrcChannel, addr.IP, true, false, false, 0, _message})
+			raise("newwebbrickfound", EventDiscovered, prev, dev)
 		} else {
-			Devices[UID].LastMessage = _message
-			passMessage("existingwebbrickupdated", *Devices[UID])
+			dev, _ := c.Devices.update(UID, func(d *Device) {
+				d.LastMessage = _message
+			})
+			raise("existingwebbrickupdated", EventUpdated, prev, dev)
 		}
 
-	case "DO": // State, e.g. Heating, State Tracking
+	case packet.TypeDigitalOutput: // State, e.g. Heating, State Tracking
 
-		_message := "Trigger on " + strconv.Itoa(resp.SourceChannel)
+		_message := "Trigger on " + strconv.Itoa(pkt.SrcChannel)
 
 		// Check to see if we've already got macAdd in our array
-		_, ok := Devices[UID]
+		prev, unknown := c.Devices.Get(UID)
 
-		if ok == false { // we haven't got this in our Devices array
-			deviceCount++
-			Devices[UID] = &Device{deviceCount, UID, "", resp.FromNodeNo, PIR, resp.SourceChannel, addr.IP, true, false, false, 0, _message}
-			passMessage("newtriggerfound", *Devices[UID])
+		if unknown != nil { // we haven't got this in our Devices array. It's an output, as polling has it, not a PIR, which come as TD
+			dev, _ := c.Devices.add(Device{0, UID, "", pkt.FromNode, STATE, pkt.SrcChannel, addr.IP, true, false, false, 0, _message})
+			raise("newtriggerfound", EventDiscovered, prev, dev)
 		} else {
-			Devices[UID].LastMessage = _message
-			passMessage("existingtriggerupdated", *Devices[UID])
+			dev, _ := c.Devices.update(UID, func(d *Device) {
+				d.LastMessage = _message
+			})
+			raise("existingtriggerupdated", EventUpdated, prev, dev)
 		}
 
-	case "CT": // Temp (?)
+	case packet.TypeTemperature: // Temp, in 16ths of a degree
 
 		// Calculate the local values
-		_value, _ := strconv.ParseFloat(resp.Value, 64)
-		_message := "Temp on " + strconv.Itoa(resp.SourceChannel) + " at " + strconv.FormatFloat((_value), 'f', 2, 64)
+		_value := float64(pkt.Value)
+		_message := "Temp on " + strconv.Itoa(pkt.SrcChannel) + " at " + strconv.FormatFloat((_value), 'f', 2, 64)
 
 		// Check to see if we've already got macAdd in our array
-		_, ok := Devices[UID]
+		prev, unknown := c.Devices.Get(UID)
 
-		if ok == false { // we haven't got this in our Devices array
-			deviceCount++
-			Devices[UID] = &Device{deviceCount, UID, "", resp.FromNodeNo, TEMP, resp.SourceChannel, addr.IP, true, false, false, _value / 16, _message}
-			passMessage("newtempfound", *Devices[UID])
+		if unknown != nil { // we haven't got this in our Devices array
+			dev, _ := c.Devices.add(Device{0, UID, "", pkt.FromNode, TEMP, pkt.SrcChannel, addr.IP, true, false, false, _value / 16, _message})
+			raise("newtempfound", EventDiscovered, prev, dev)
 		} else {
-			Devices[UID].LastMessage = _message
-			Devices[UID].Level = (_value / 16)
-			passMessage("existingtempupdated", *Devices[UID])
+			dev, _ := c.Devices.update(UID, func(d *Device) {
+				d.LastMessage = _message
+				d.Level = (_value / 16)
+			})
+			raise("existingtempupdated", EventUpdated, prev, dev)
 		}
 
-	case "TD": // Button (?) Check is this includes PIR as well
+	case packet.TypeDigitalTrigger: // Button, or a PIR if it is in the PIR list
 
 		// Check to see if we've already got macAdd in our array
-		_, ok := Devices[UID]
+		prev, unknown := c.Devices.Get(UID)
 
-		if !PIRS[UID] {
-			_message := "Button pressed on " + strconv.Itoa(resp.SourceChannel)
+		if !c.Pirs[UID] {
+			_message := "Button pressed on " + strconv.Itoa(pkt.SrcChannel)
 
-			if ok == false { // we haven't got this in our Devices array
-				deviceCount++
-				Devices[UID] = &Device{deviceCount, UID, "", resp.FromNodeNo, BUTTON, resp.SourceChannel, addr.IP, true, false, false, 0, _message}
-				passMessage("newbuttonfound", *Devices[UID])
+			if unknown != nil { // we haven't got this in our Devices array
+				dev, _ := c.Devices.add(Device{0, UID, "", pkt.FromNode, BUTTON, pkt.SrcChannel, addr.IP, true, false, false, 0, _message})
+				raise("newbuttonfound", EventDiscovered, prev, dev)
 			} else {
-				Devices[UID].LastMessage = _message
-				Devices[UID].State = true
-				passMessage("existingbuttonupdated", *Devices[UID])
+				dev, _ := c.Devices.update(UID, func(d *Device) {
+					d.LastMessage = _message
+					d.State = true
+				})
+				raise("existingbuttonupdated", EventUpdated, prev, dev)
 			}
 
 		} else {
 
-			_message := "PIR actioned on " + strconv.Itoa(resp.SourceChannel)
+			_message := "PIR actioned on " + strconv.Itoa(pkt.SrcChannel)
 
-			if ok == false { // we haven't got this in our Devices array
-				deviceCount++
-				Devices[UID] = &Device{deviceCount, UID, "", resp.FromNodeNo, PIR, resp.SourceChannel, addr.IP, true, false, false, 0, _message}
-				passMessage("newpirfound", *Devices[UID])
+			if unknown != nil { // we haven't got this in our Devices array
+				dev, _ := c.Devices.add(Device{0, UID, "", pkt.FromNode, PIR, pkt.SrcChannel, addr.IP, true, false, false, 0, _message})
+				raise("newpirfound", EventDiscovered, prev, dev)
 			} else {
-				Devices[UID].LastMessage = _message
-				Devices[UID].State = true
-				passMessage("existingpirtriggered", *Devices[UID])
+				dev, _ := c.Devices.update(UID, func(d *Device) {
+					d.LastMessage = _message
+					d.State = true
+				})
+				raise("existingpirtriggered", EventUpdated, prev, dev)
 			}
 
 		}
 
-	case "AO": // Light Dimmer Device
+	case packet.TypeAnalogueOutput: // Light Dimmer Device
 
 		// Calculate the private values for the message
 		var _state bool
-		_message := "Light at level " + resp.Value
-		//_value, _ := strconv.Atoi(resp.Value)
-		_value, _ := strconv.ParseFloat(resp.Value, 64)
+		_message := "Light at level " + strconv.Itoa(pkt.Value)
+		_value := float64(pkt.Value)
 		if _value > 0 {
 			_state = true
 		} else {
@@ -1030,18 +1047,22 @@ func handleMessage(buf []byte, addr *net.UDPAddr) (bool, error) {
 		}
 
 		// Check to see if we've already got macAdd in our array
-		_, ok := Devices[UID]
+		prev, unknown := c.Devices.Get(UID)
 
-		if ok == false { // we haven't got this in our Devices array
-			deviceCount++
-			Devices[UID] = &Device{deviceCount, UID, "", resp.FromNodeNo, LIGHT, resp.SourceChannel, addr.IP, true, false, _state, _value, _message}
-			passMessage("newlightchannelfound", *Devices[UID])
+		if unknown != nil { // we haven't got this in our Devices array
+			dev, _ := c.Devices.add(Device{0, UID, "", pkt.FromNode, LIGHT, pkt.SrcChannel, addr.IP, true, false, _state, _value, _message})
+			raise("newlightchannelfound", EventDiscovered, prev, dev)
 		} else {
-			Devices[UID].State = _state
-			Devices[UID].Level = _value
-			Devices[UID].LastMessage = _message
-			passMessage("existinglightchannelupdated", *Devices[UID])
+			dev, _ := c.Devices.update(UID, func(d *Device) {
+				d.State = _state
+				d.Level = _value
+				d.LastMessage = _message
+			})
+			raise("existinglightchannelupdated", EventUpdated, prev, dev)
 		}
+
+	default:
+		myLog.Debugf("No handler for %s packets from %s", pkt.Type, UID)
 	}
 	return true, nil
 }
@@ -1084,35 +1105,8 @@ func getLocalIP() (string, error) {
 	return "", errors.New("Unable to find IP address. Ensure you're connected to a network")
 }
 
-// passMessage adds items to our Events channel so the calling code can be informed
-// It's non-blocking or whatever.
-func passMessage(message string, device Device) bool {
-
-	select {
-	case Events <- EventStruct{message, device}:
-
-	default:
-	}
-
-	return true
-}
-
-// sendCommand is the key instruction part of the library
-//		success, err := sendCommand(command, devID)
-func sendCommand(command string, devID string) (bool, error) {
-
-	resp, err := http.Get(command)
-	if err != nil {
-		return false, err
-	}
-	defer resp.Body.Close()
-
-	// Dont need to read the response yet
-	// its fire and hope and forget
-	body, err := ioutil.ReadAll(resp.Body)
-	myLog.Infof("**** Body Message ****", body)
-
-	// Looks good
-	return true, nil
-
+// percent turns a 0 to 1 level into the 0 to 100 the bricks, and Device.Level, use.
+// It's the only place levels get scaled
+func percent(level float64) int {
+	return int(math.Round(level * 100))
 }